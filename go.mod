@@ -3,8 +3,12 @@ module github.com/wolframdeus/noitifications-service
 go 1.18
 
 require (
-	github.com/SevereCloud/vksdk/v2 v2.15.0 // indirect
-	github.com/getsentry/sentry-go v0.13.0 // indirect
+	github.com/SevereCloud/vksdk/v2 v2.15.0
+	github.com/getsentry/sentry-go v0.13.0
+	go.mongodb.org/mongo-driver v1.10.0
+)
+
+require (
 	github.com/golang/snappy v0.0.1 // indirect
 	github.com/klauspost/compress v1.15.8 // indirect
 	github.com/montanaflynn/stats v0.0.0-20171201202039-1bf9dbcd8cbe // indirect
@@ -15,7 +19,6 @@ require (
 	github.com/xdg-go/scram v1.1.1 // indirect
 	github.com/xdg-go/stringprep v1.0.3 // indirect
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
-	go.mongodb.org/mongo-driver v1.10.0 // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20211007075335-d3039528d8ac // indirect
@@ -0,0 +1,160 @@
+package campaign
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"sync"
+	"time"
+)
+
+// Progress описывает ход выполнения кампании. Каждый пользователь
+// учитывается в счетчиках не более одного раза.
+type Progress struct {
+	// Количество пользователей, переданных в задачу кампании.
+	Matched int
+	// Количество пользователей, которым уведомление было успешно отправлено.
+	Sent int
+	// Количество пользователей, которым уведомление отправить не удалось, в
+	// том числе при повторных попытках.
+	Failed int
+	// Завершена ли кампания.
+	Completed bool
+}
+
+// Campaign описывает разовую рассылку уведомления в указанную локальную дату
+// и промежуток времени пользователя. Каждому пользователю уведомление
+// отправляется не более одного раза.
+type Campaign struct {
+	// Задача, выполняющая рассылку.
+	Task task.Task
+	// Локальная дата рассылки.
+	Date task.Date
+
+	mu       sync.Mutex
+	progress Progress
+	// Пользователи, переданные в задачу кампании.
+	matched map[user.Id]bool
+	// Результаты отправки уведомлений пользователям. Значение равно true в
+	// случае, если уведомление было успешно отправлено.
+	delivered map[user.Id]bool
+}
+
+// EndsAt возвращает момент, когда промежуток отправки завершится в
+// последнем часовом поясе.
+func (c *Campaign) EndsAt() time.Time {
+	offset := -time.Duration(timezone.MinTimezone) * time.Minute
+	start := time.Date(
+		c.Date.Year,
+		c.Date.Month,
+		c.Date.Day,
+		int(c.Task.Window.From.Hours),
+		int(c.Task.Window.From.Minutes),
+		int(c.Task.Window.From.Seconds),
+		0,
+		time.UTC,
+	).Add(offset)
+
+	return c.Task.GetWindowEnd(timezone.MinTimezone, start)
+}
+
+// IsCompleted возвращает true в случае, если в момент now промежуток
+// отправки завершился во всех часовых поясах.
+func (c *Campaign) IsCompleted(now time.Time) bool {
+	return !now.Before(c.EndsAt())
+}
+
+// Progress возвращает ход выполнения кампании.
+func (c *Campaign) Progress() Progress {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.progress
+}
+
+// AddMatched учитывает пользователей, переданных в задачу кампании.
+// Пользователи, учтенные ранее, повторно не учитываются.
+func (c *Campaign) AddMatched(userIds []user.Id) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.matched == nil {
+		c.matched = make(map[user.Id]bool)
+	}
+	for _, uid := range userIds {
+		if c.matched[uid] {
+			continue
+		}
+		c.matched[uid] = true
+		c.progress.Matched++
+	}
+}
+
+// AddSendResult учитывает результат отправки уведомлений кампании.
+// Пользователь, уведомление которому удалось отправить при повторной
+// попытке, перестает учитываться как неотправленный.
+func (c *Campaign) AddSendResult(res *notification.SendResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.delivered == nil {
+		c.delivered = make(map[user.Id]bool)
+	}
+	for _, uid := range res.Success {
+		sent, ok := c.delivered[uid]
+		if sent {
+			continue
+		}
+		if ok {
+			c.progress.Failed--
+		}
+		c.delivered[uid] = true
+		c.progress.Sent++
+	}
+
+	failed := [][]user.Id{
+		res.NotificationsDisabled,
+		res.UnknownError,
+		res.HourRateLimitReached,
+		res.DayRateLimitReached,
+		res.InternalError,
+	}
+	for _, userIds := range failed {
+		for _, uid := range userIds {
+			if _, ok := c.delivered[uid]; ok {
+				continue
+			}
+			c.delivered[uid] = false
+			c.progress.Failed++
+		}
+	}
+}
+
+// Complete отмечает кампанию как завершенную.
+func (c *Campaign) Complete() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.progress.Completed = true
+}
+
+// New возвращает ссылку на новый экземпляр Campaign. Уведомление
+// отправляется в дату date в промежуток window по локальному времени
+// пользователя.
+func New(
+	id taskid.Id,
+	appId appid.Id,
+	date task.Date,
+	window internal.Window,
+	process task.ProcessFunc,
+) *Campaign {
+	t := task.NewTask(id, appId, window, process)
+	t.Schedule = task.Schedule{Dates: []task.Date{date}}
+	t.Caps = task.Caps{MaxTotal: 1}
+
+	return &Campaign{Task: *t, Date: date}
+}
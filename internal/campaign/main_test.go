@@ -0,0 +1,71 @@
+package campaign
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"testing"
+)
+
+func TestCampaignAddSendResult(t *testing.T) {
+	tests := []struct {
+		name     string
+		results  []notification.SendResult
+		expected Progress
+	}{
+		{
+			name: "повторная отправка пользователю",
+			results: []notification.SendResult{
+				{Success: []user.Id{1, 2}},
+				{Success: []user.Id{1}},
+			},
+			expected: Progress{Sent: 2},
+		},
+		{
+			name: "повторная ошибка отправки",
+			results: []notification.SendResult{
+				{InternalError: []user.Id{1}},
+				{UnknownError: []user.Id{1}, HourRateLimitReached: []user.Id{2}},
+			},
+			expected: Progress{Failed: 2},
+		},
+		{
+			name: "успешная повторная попытка",
+			results: []notification.SendResult{
+				{InternalError: []user.Id{1, 2}},
+				{Success: []user.Id{1}},
+			},
+			expected: Progress{Sent: 1, Failed: 1},
+		},
+		{
+			name: "ошибка после успешной отправки",
+			results: []notification.SendResult{
+				{Success: []user.Id{1}},
+				{InternalError: []user.Id{1}},
+			},
+			expected: Progress{Sent: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Campaign{}
+			for i := range tt.results {
+				c.AddSendResult(&tt.results[i])
+			}
+
+			if got := c.Progress(); got != tt.expected {
+				t.Errorf("ожидалось %+v, получено %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCampaignAddMatched(t *testing.T) {
+	c := &Campaign{}
+	c.AddMatched([]user.Id{1, 2})
+	c.AddMatched([]user.Id{2, 3})
+
+	if got := c.Progress().Matched; got != 3 {
+		t.Errorf("ожидалось 3 пользователя, получено %d", got)
+	}
+}
@@ -0,0 +1,97 @@
+package checkpoint
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+// Status описывает состояние итерации.
+type Status string
+
+const (
+	// StatusRunning - итерация выполняется.
+	StatusRunning Status = "running"
+	// StatusInterrupted - итерация была прервана и должна быть продолжена.
+	StatusInterrupted Status = "interrupted"
+	// StatusCompleted - итерация завершена.
+	StatusCompleted Status = "completed"
+)
+
+// TaskCounters описывает счетчики задачи в рамках итерации.
+type TaskCounters struct {
+	// Идентификатор приложения-владельца задачи.
+	AppId appid.Id
+	// Идентификатор задачи.
+	TaskId taskid.Id
+	// Количество пользователей, переданных в задачу.
+	Matched int
+	// Количество пользователей, исключенных ввиду ограничений частоты
+	// отправки задачи.
+	Capped int
+	// Количество пользователей, исключенных ввиду исчерпания ограничений
+	// приложения.
+	OverBudget int
+	// Количество пользователей, переданных в задачу группы с большим
+	// приоритетом, либо уже получивших уведомление другой задачи группы.
+	Excluded int
+	// Количество пользователей, которым уведомление было успешно отправлено.
+	Sent int
+	// Количество пользователей, которым уведомление отправить не удалось.
+	Failed int
+}
+
+// Checkpoint описывает сохраненное состояние итерации сервиса, которое
+// позволяет продолжить её выполнение после перезапуска.
+type Checkpoint struct {
+	// Идентификатор записи. Устанавливается провайдером при первом
+	// сохранении.
+	Id string
+	// Дата начала итерации.
+	StartedAt time.Time
+	// Дата последнего обновления записи.
+	UpdatedAt time.Time
+	// Интервалы часовых поясов, пользователи которых обрабатывались
+	// итерацией.
+	Ranges []timezone.Range
+	// Курсор, до которого (включительно) пользователи были обработаны.
+	Cursor user.Id
+	// Счетчики задач.
+	Tasks []TaskCounters
+	// Состояние итерации.
+	Status Status
+}
+
+// GetTask возвращает ссылку на счетчики указанной задачи. В случае, если
+// счетчиков задачи ещё нет, они создаются.
+func (c *Checkpoint) GetTask(appId appid.Id, taskId taskid.Id) *TaskCounters {
+	for i := range c.Tasks {
+		if c.Tasks[i].AppId == appId && c.Tasks[i].TaskId == taskId {
+			return &c.Tasks[i]
+		}
+	}
+	c.Tasks = append(c.Tasks, TaskCounters{AppId: appId, TaskId: taskId})
+
+	return &c.Tasks[len(c.Tasks)-1]
+}
+
+// Copy возвращает ссылку на глубокую копию записи.
+func (c *Checkpoint) Copy() *Checkpoint {
+	res := *c
+	res.Ranges = append([]timezone.Range(nil), c.Ranges...)
+	res.Tasks = append([]TaskCounters(nil), c.Tasks...)
+
+	return &res
+}
+
+// New возвращает ссылку на новую запись о начатой итерации.
+func New(startedAt time.Time, ranges []timezone.Range) *Checkpoint {
+	return &Checkpoint{
+		StartedAt: startedAt,
+		UpdatedAt: startedAt,
+		Ranges:    ranges,
+		Status:    StatusRunning,
+	}
+}
@@ -0,0 +1,56 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock описывает источник текущего времени. Используется для того, чтобы
+// все вычисления в рамках итерации выполнялись относительно одного момента
+// времени, а также для подмены времени в тестах.
+type Clock interface {
+	// Now возвращает текущее время.
+	Now() time.Time
+}
+
+// Real - источник текущего времени, использующий системные часы.
+type Real struct{}
+
+func (Real) Now() time.Time {
+	return time.Now()
+}
+
+// Fake - источник времени, значение которого изменяется только вручную.
+type Fake struct {
+	mu  sync.RWMutex
+	now time.Time
+}
+
+func (f *Fake) Now() time.Time {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	return f.now
+}
+
+// Set устанавливает текущее время.
+func (f *Fake) Set(now time.Time) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = now
+}
+
+// Add сдвигает текущее время на указанный промежуток.
+func (f *Fake) Add(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+}
+
+// NewFake возвращает ссылку на новый экземпляр Fake с указанным текущим
+// временем.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
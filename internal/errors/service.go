@@ -3,6 +3,9 @@ package errors
 type ServiceError struct {
 	// Оригинальная выброшенная ошибка.
 	Original error
+	// Является ли ошибка временной. Операция, завершившаяся временной
+	// ошибкой, может быть выполнена повторно.
+	Retryable bool
 }
 
 // NewServiceError возвращает ссылку на новый экземпляр ServiceError.
@@ -11,3 +14,12 @@ func NewServiceError(err error) *ServiceError {
 		Original: err,
 	}
 }
+
+// NewRetryableServiceError возвращает ссылку на новый экземпляр
+// ServiceError, описывающий временную ошибку.
+func NewRetryableServiceError(err error) *ServiceError {
+	return &ServiceError{
+		Original:  err,
+		Retryable: true,
+	}
+}
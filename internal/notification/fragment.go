@@ -0,0 +1,47 @@
+package notification
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	// MaxFragmentLength - максимальная длина фрагмента уведомления.
+	MaxFragmentLength = 2048
+)
+
+var (
+	ErrFragmentTooLong     = fmt.Errorf("длина фрагмента превышает %d символов", MaxFragmentLength)
+	ErrFragmentInvalidChar = errors.New("фрагмент содержит недопустимый символ")
+)
+
+// ValidateFragment проверяет, может ли переданная строка быть использована в
+// качестве фрагмента уведомления. Фрагмент является частью URL приложения
+// после символа "#" и может содержать только символы, допустимые в этой
+// части URL (RFC 3986).
+func ValidateFragment(fragment string) error {
+	if len(fragment) > MaxFragmentLength {
+		return ErrFragmentTooLong
+	}
+
+	for i := 0; i < len(fragment); i++ {
+		if !isFragmentChar(fragment[i]) {
+			return fmt.Errorf("%w: %q", ErrFragmentInvalidChar, fragment[i])
+		}
+	}
+	return nil
+}
+
+// Возвращает true в случае, если символ допустим во фрагменте URL.
+func isFragmentChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	switch c {
+	case '-', '.', '_', '~', '!', '$', '&', '\'', '(', ')', '*', '+', ',', ';',
+		'=', ':', '@', '/', '?', '%':
+		return true
+	}
+	return false
+}
@@ -1,16 +1,34 @@
 package notification
 
-import "github.com/wolframdeus/noitifications-service/internal/user"
+import (
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+const (
+	// HourRateLimitCooldown - время, в течение которого пользователю не
+	// отправляются уведомления приложения после достижения часового лимита.
+	HourRateLimitCooldown = time.Hour
+	// DayRateLimitCooldown - время, в течение которого пользователю не
+	// отправляются уведомления приложения после достижения дневного лимита.
+	DayRateLimitCooldown = 24 * time.Hour
+)
 
 type Params struct {
 	// Идентификатор пользователя которому надо отправить уведомление.
 	UserId user.Id
 	// Текст уведомления.
 	Message string
-	// Фрагмент, который необходимо использовать в уведомлении.
+	// Фрагмент, который необходимо использовать в уведомлении. Позволяет
+	// открыть определенный экран приложения при переходе по уведомлению.
 	Fragment string
 }
 
+// Validate проверяет корректность параметров уведомления.
+func (p *Params) Validate() error {
+	return ValidateFragment(p.Fragment)
+}
+
 type SendResult struct {
 	// Список пользователей, отправка уведомлений которым была успешна.
 	Success []user.Id
@@ -28,4 +46,34 @@ type SendResult struct {
 	// Список пользователей, которым не удалось отправить уведомление ввиду
 	// внутренней ошибки.
 	InternalError []user.Id
+	// Список ошибок, возникших при отправке пачек уведомлений.
+	Errors []BatchError
+}
+
+// Merge добавляет в текущий результат все значения другого результата.
+func (r *SendResult) Merge(other *SendResult) {
+	r.Success = append(r.Success, other.Success...)
+	r.NotificationsDisabled = append(r.NotificationsDisabled, other.NotificationsDisabled...)
+	r.UnknownError = append(r.UnknownError, other.UnknownError...)
+	r.HourRateLimitReached = append(r.HourRateLimitReached, other.HourRateLimitReached...)
+	r.DayRateLimitReached = append(r.DayRateLimitReached, other.DayRateLimitReached...)
+	r.InternalError = append(r.InternalError, other.InternalError...)
+	r.Errors = append(r.Errors, other.Errors...)
+}
+
+// BatchError описывает ошибку, возникшую при отправке пачки уведомлений.
+type BatchError struct {
+	// Текст уведомления пачки.
+	Message string
+	// Фрагмент уведомления пачки.
+	Fragment string
+	// Количество пользователей в пачке.
+	BatchSize int
+	// Список пользователей пачки, которых коснулась ошибка.
+	UserIds []user.Id
+	// Код ошибки, который вернул способ доставки. Равен 0 в случае, если
+	// код ошибки неизвестен.
+	Code int
+	// Оригинальная ошибка.
+	Original error
 }
@@ -1,8 +1,63 @@
 package providers
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	// BucketSuccess - раздел результата с пользователями, которым уведомление
+	// было успешно отправлено.
+	BucketSuccess = "Success"
+	// BucketNotificationsDisabled - раздел результата с пользователями,
+	// которые запретили отправку уведомлений.
+	BucketNotificationsDisabled = "NotificationsDisabled"
+	// BucketHourRateLimitReached - раздел результата с пользователями, у
+	// которых достигнут часовой лимит уведомлений.
+	BucketHourRateLimitReached = "HourRateLimitReached"
+	// BucketDayRateLimitReached - раздел результата с пользователями, у
+	// которых достигнут дневной лимит уведомлений.
+	BucketDayRateLimitReached = "DayRateLimitReached"
+)
 
 var (
 	ErrUserDoesNotExist  = errors.New("пользователь не существует")
 	ErrUserAlreadyExists = errors.New("пользователь уже существует")
 )
+
+// SaveSendResultError описывает ошибку сохранения результатов отправки
+// уведомлений.
+type SaveSendResultError struct {
+	// Разделы результата отправки, сохранить которые не удалось, и
+	// количество пользователей в каждом из них, для которых сохранение
+	// завершилось ошибкой.
+	FailedBuckets map[string]int
+	// Оригинальная выброшенная ошибка.
+	Original error
+}
+
+func (e *SaveSendResultError) Error() string {
+	buckets := make([]string, 0, len(e.FailedBuckets))
+	for b, count := range e.FailedBuckets {
+		buckets = append(buckets, fmt.Sprintf("%s (%d)", b, count))
+	}
+	sort.Strings(buckets)
+
+	return fmt.Sprintf(
+		"не удалось сохранить результаты отправки: %s: %v",
+		strings.Join(buckets, ", "),
+		e.Original,
+	)
+}
+
+func (e *SaveSendResultError) Unwrap() error {
+	return e.Original
+}
+
+// NewSaveSendResultError возвращает ссылку на новый экземпляр
+// SaveSendResultError.
+func NewSaveSendResultError(failedBuckets map[string]int, err error) *SaveSendResultError {
+	return &SaveSendResultError{FailedBuckets: failedBuckets, Original: err}
+}
@@ -1,9 +1,12 @@
 package providers
 
 import (
+	"context"
 	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
 	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
 	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
 	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/timezone"
 	"github.com/wolframdeus/noitifications-service/internal/user"
@@ -32,23 +35,74 @@ func NewGetUsersByTimezonesResult(
 
 type Provider interface {
 	// GetUsersByTimezones возвращает пользователей удовлетворяющих условию
-	// наличия часового пояса.
-	GetUsersByTimezones(tz []timezone.Range, cursor user.Id) (*GetUsersByTimezonesResult, *customerror.ServiceError)
+	// наличия часового пояса, которые разрешили отправку уведомлений хотя бы
+	// одному из указанных приложений. Часовой пояс пользователей IANA, а также
+	// ограничения на отправку определяются на момент времени now.
+	GetUsersByTimezones(
+		ctx context.Context,
+		appIds []appid.Id,
+		tz []timezone.Range,
+		cursor user.Id,
+		now time.Time,
+	) (*GetUsersByTimezonesResult, *customerror.ServiceError)
+
+	// GetUsers возвращает пользователей с указанными идентификаторами.
+	// Отсутствующие пользователи в результат не попадают.
+	GetUsers(ctx context.Context, userIds []user.Id) ([]user.User, *customerror.ServiceError)
 
 	// SetAllowStatusForUser - функция для изменения разрешения на отправку
 	// уведомлений пользователю.
 	SetAllowStatusForUser(
+		ctx context.Context,
 		userId user.Id,
 		appId appid.Id,
 		allowed bool,
 		user *user.User,
 	) *customerror.ServiceError
 
-	// SaveSendResult сохраняет результаты отправки уведомлений.
+	// SaveSendResult сохраняет результаты отправки уведомлений. Параметры
+	// отправленных уведомлений используются для сохранения истории отправки.
+	// Для пользователей, которым удалось отправить уведомление, увеличивает
+	// счетчик отправок задачи и обновляет дату последней отправки. Параметр
+	// historyLimit задает минимальную длину истории отправки задачи, которая
+	// должна храниться. Провайдер хранит не меньше записей, чем его
+	// собственное ограничение.
 	SaveSendResult(
+		ctx context.Context,
 		results *notification.SendResult,
+		params []notification.Params,
 		appId appid.Id,
 		taskId taskid.Id,
 		date time.Time,
+		historyLimit uint,
 	) *customerror.ServiceError
+
+	// SaveCheckpoint сохраняет запись об итерации сервиса. В случае, если
+	// идентификатор записи не указан, создаёт новую запись и устанавливает
+	// её идентификатор.
+	SaveCheckpoint(ctx context.Context, c *checkpoint.Checkpoint) *customerror.ServiceError
+
+	// GetUnfinishedCheckpoint возвращает последнюю незавершенную запись об
+	// итерации сервиса, обновленную не раньше since. В случае, если такой
+	// записи нет, возвращает nil.
+	GetUnfinishedCheckpoint(
+		ctx context.Context,
+		since time.Time,
+	) (*checkpoint.Checkpoint, *customerror.ServiceError)
+
+	// SaveRetryItems сохраняет уведомления для повторной отправки. Записям без
+	// идентификатора устанавливается новый идентификатор.
+	SaveRetryItems(ctx context.Context, items []retry.Item) *customerror.ServiceError
+
+	// GetDueRetryItems возвращает не более limit уведомлений для повторной
+	// отправки, время следующей попытки которых уже наступило.
+	GetDueRetryItems(ctx context.Context, now time.Time, limit int64) ([]retry.Item, *customerror.ServiceError)
+
+	// GetRetryItemsByUsers возвращает все уведомления для повторной отправки
+	// указанным пользователям.
+	GetRetryItemsByUsers(ctx context.Context, userIds []user.Id) ([]retry.Item, *customerror.ServiceError)
+
+	// DeleteRetryItems удаляет уведомления для повторной отправки с
+	// указанными идентификаторами.
+	DeleteRetryItems(ctx context.Context, ids []string) *customerror.ServiceError
 }
@@ -0,0 +1,119 @@
+package mongodb
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type CheckpointRange struct {
+	// Начало промежутка.
+	From int `bson:"from"`
+	// Конец промежутка.
+	To int `bson:"to"`
+}
+
+type CheckpointTask struct {
+	// Идентификатор приложения-владельца задачи.
+	AppId AppId `bson:"appId"`
+	// Идентификатор задачи.
+	TaskId TaskId `bson:"taskId"`
+	// Количество пользователей, переданных в задачу.
+	Matched int `bson:"matched"`
+	// Количество пользователей, исключенных ввиду ограничений частоты
+	// отправки задачи.
+	Capped int `bson:"capped"`
+	// Количество пользователей, исключенных ввиду исчерпания ограничений
+	// приложения.
+	OverBudget int `bson:"overBudget"`
+	// Количество пользователей, переданных в задачу группы с большим
+	// приоритетом, либо уже получивших уведомление другой задачи группы.
+	Excluded int `bson:"excluded"`
+	// Количество пользователей, которым уведомление было успешно отправлено.
+	Sent int `bson:"sent"`
+	// Количество пользователей, которым уведомление отправить не удалось.
+	Failed int `bson:"failed"`
+}
+
+type Checkpoint struct {
+	// Идентификатор записи.
+	Id primitive.ObjectID `bson:"_id"`
+	// Дата начала итерации.
+	StartedAt time.Time `bson:"startedAt"`
+	// Дата последнего обновления записи.
+	UpdatedAt time.Time `bson:"updatedAt"`
+	// Интервалы часовых поясов, пользователи которых обрабатывались
+	// итерацией.
+	Ranges []CheckpointRange `bson:"ranges"`
+	// Курсор, до которого (включительно) пользователи были обработаны.
+	Cursor UserId `bson:"cursor"`
+	// Счетчики задач.
+	Tasks []CheckpointTask `bson:"tasks"`
+	// Состояние итерации.
+	Status string `bson:"status"`
+}
+
+// ToCommon конвертирует текущую запись к общему виду.
+func (c *Checkpoint) ToCommon() *checkpoint.Checkpoint {
+	res := &checkpoint.Checkpoint{
+		Id:        c.Id.Hex(),
+		StartedAt: c.StartedAt,
+		UpdatedAt: c.UpdatedAt,
+		Ranges:    make([]timezone.Range, len(c.Ranges)),
+		Cursor:    user.Id(c.Cursor),
+		Tasks:     make([]checkpoint.TaskCounters, len(c.Tasks)),
+		Status:    checkpoint.Status(c.Status),
+	}
+
+	for i, r := range c.Ranges {
+		res.Ranges[i] = *timezone.NewRange(timezone.Timezone(r.From), timezone.Timezone(r.To))
+	}
+	for i, t := range c.Tasks {
+		res.Tasks[i] = checkpoint.TaskCounters{
+			AppId:      appid.Id(t.AppId),
+			TaskId:     taskid.Id(t.TaskId),
+			Matched:    t.Matched,
+			Capped:     t.Capped,
+			OverBudget: t.OverBudget,
+			Excluded:   t.Excluded,
+			Sent:       t.Sent,
+			Failed:     t.Failed,
+		}
+	}
+	return res
+}
+
+// NewCheckpoint создает ссылку на новый экземпляр Checkpoint из записи
+// общего вида.
+func NewCheckpoint(id primitive.ObjectID, c *checkpoint.Checkpoint) *Checkpoint {
+	res := &Checkpoint{
+		Id:        id,
+		StartedAt: c.StartedAt,
+		UpdatedAt: c.UpdatedAt,
+		Ranges:    make([]CheckpointRange, len(c.Ranges)),
+		Cursor:    UserId(c.Cursor),
+		Tasks:     make([]CheckpointTask, len(c.Tasks)),
+		Status:    string(c.Status),
+	}
+
+	for i, r := range c.Ranges {
+		res.Ranges[i] = CheckpointRange{From: int(r.From), To: int(r.To)}
+	}
+	for i, t := range c.Tasks {
+		res.Tasks[i] = CheckpointTask{
+			AppId:      AppId(t.AppId),
+			TaskId:     TaskId(t.TaskId),
+			Matched:    t.Matched,
+			Capped:     t.Capped,
+			OverBudget: t.OverBudget,
+			Excluded:   t.Excluded,
+			Sent:       t.Sent,
+			Failed:     t.Failed,
+		}
+	}
+	return res
+}
@@ -0,0 +1,15 @@
+package mongodb
+
+import (
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// Создает ссылку на экземпляр ServiceError из ошибки MongoDB. Сетевые ошибки
+// и ошибки таймаута считаются временными.
+func newServiceError(err error) *customerror.ServiceError {
+	if mongo.IsNetworkError(err) || mongo.IsTimeout(err) {
+		return customerror.NewRetryableServiceError(err)
+	}
+	return customerror.NewServiceError(err)
+}
@@ -2,20 +2,39 @@ package mongodb
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
 	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
 	"github.com/wolframdeus/noitifications-service/internal/notification"
 	"github.com/wolframdeus/noitifications-service/internal/providers"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
 	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/timezone"
 	"github.com/wolframdeus/noitifications-service/internal/user"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"sync"
 	"time"
 )
 
+const (
+	// DefaultHistoryLimit - максимальное количество записей в истории
+	// отправки задачи по умолчанию.
+	DefaultHistoryLimit = 15
+
+	// Время, по истечении которого записи об итерациях удаляются.
+	checkpointsTTL = 7 * 24 * time.Hour
+	// Максимальное время создания индексов при создании провайдера.
+	createIndexesTimeout = 30 * time.Second
+	// Время, в течение которого используется полученный из БД список
+	// часовых поясов IANA пользователей.
+	timezoneNamesTTL = time.Minute
+)
+
 type Provider struct {
 	// Клиент MongoDB.
 	client *mongo.Client
@@ -24,48 +43,97 @@ type Provider struct {
 	// Максимальное количество пользователей, которое может быть возвращено
 	// методом GetUsersByTimezones.
 	getUsersByTimezonesLimit int64
+	// Минимальное количество записей в истории отправки задачи.
+	historyLimit    uint
+	timezoneNamesMu sync.Mutex
+	// Наименования часовых поясов IANA пользователей, которые удалось
+	// загрузить.
+	timezoneNames []string
+	// Дата получения наименований часовых поясов из БД.
+	timezoneNamesUpdatedAt time.Time
+}
+
+type NewOptions struct {
+	// Количество записей в истории отправки задачи. Задачи могут
+	// увеличить его для себя, но не уменьшить. По умолчанию
+	// DefaultHistoryLimit.
+	HistoryLimit uint
 }
 
 func (p *Provider) GetUsersByTimezones(
+	ctx context.Context,
+	appIds []appid.Id,
 	tz []timezone.Range,
 	cursor user.Id,
+	now time.Time,
 ) (*providers.GetUsersByTimezonesResult, *customerror.ServiceError) {
-	if len(tz) == 0 {
+	if len(tz) == 0 || len(appIds) == 0 {
 		return providers.NewGetUsersByTimezonesResult(0, nil, false), nil
 	}
-	// Составляем условие запроса для MongoDB.
-	orQuery := make([]bson.M, len(tz))
-	for i, t := range tz {
-		orQuery[i] = bson.M{"timezone": bson.M{"$gte": t.From, "$lte": t.To}}
+
+	// Получаем часовые поясы IANA пользователей, текущее смещение которых
+	// попадает в указанные интервалы.
+	validNames, matchedNames, namesErr := p.getTimezoneNames(ctx, tz, now)
+	if namesErr != nil {
+		return nil, namesErr
+	}
+
+	// Составляем условие запроса для MongoDB. Фиксированное смещение
+	// используется только для пользователей без корректного часового пояса
+	// IANA.
+	tzQuery := make([]bson.M, 0, len(tz)+1)
+	for _, t := range tz {
+		tzQuery = append(tzQuery, bson.M{
+			"timezone":     bson.M{"$gte": t.From, "$lte": t.To},
+			"timezoneName": bson.M{"$nin": validNames},
+		})
+	}
+	if len(matchedNames) > 0 {
+		tzQuery = append(tzQuery, bson.M{"timezoneName": bson.M{"$in": matchedNames}})
+	}
+
+	// Пользователь должен разрешить отправку уведомлений хотя бы одному из
+	// приложений, при этом для этого приложения у него не должно быть
+	// действующего ограничения на отправку.
+	appsQuery := make([]bson.M, len(appIds))
+	for i, id := range appIds {
+		appsQuery[i] = bson.M{
+			fmt.Sprintf("apps.%d.areNotificationsEnabled", id): true,
+			fmt.Sprintf("apps.%d.cooldownUntil", id):           bson.M{"$not": bson.M{"$gt": now}},
+		}
 	}
 
 	cur, err := p.
 		getUsersCollection().
 		Find(
-			context.Background(),
+			ctx,
 			bson.M{
-				"_id": bson.M{"$gt": cursor},
-				"$or": orQuery,
+				"_id":  bson.M{"$gt": cursor},
+				"$and": []bson.M{{"$or": tzQuery}, {"$or": appsQuery}},
 			},
 			options.
 				Find().
 				SetLimit(p.getUsersByTimezonesLimit+1).
-				SetSort(bson.D{{"_id", 1}}),
+				SetSort(bson.D{{Key: "_id", Value: 1}}),
 		)
 	if err != nil {
-		return nil, customerror.NewServiceError(err)
+		return nil, newServiceError(err)
 	}
+	defer cur.Close(ctx)
 
 	var users []user.User
 
-	for cur.Next(context.TODO()) {
+	for cur.Next(ctx) {
 		var u User
 
 		if err := cur.Decode(&u); err != nil {
-			return nil, customerror.NewServiceError(err)
+			return nil, newServiceError(err)
 		}
 		users = append(users, *u.ToCommon())
 	}
+	if err := cur.Err(); err != nil {
+		return nil, newServiceError(err)
+	}
 
 	// Пользователей нет, возвращаем стандартный ответ.
 	if len(users) == 0 {
@@ -83,7 +151,101 @@ func (p *Provider) GetUsersByTimezones(
 	return providers.NewGetUsersByTimezonesResult(users[len(users)-1].Id, users, hasMore), nil
 }
 
+func (p *Provider) GetUsers(
+	ctx context.Context,
+	userIds []user.Id,
+) ([]user.User, *customerror.ServiceError) {
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+	cur, err := p.getUsersCollection().Find(ctx, bson.M{"_id": bson.M{"$in": userIds}})
+	if err != nil {
+		return nil, newServiceError(err)
+	}
+	defer cur.Close(ctx)
+
+	var users []user.User
+
+	for cur.Next(ctx) {
+		var u User
+
+		if err := cur.Decode(&u); err != nil {
+			return nil, newServiceError(err)
+		}
+		users = append(users, *u.ToCommon())
+	}
+	if err := cur.Err(); err != nil {
+		return nil, newServiceError(err)
+	}
+	return users, nil
+}
+
+// Возвращает наименования часовых поясов IANA, указанных у пользователей,
+// которые удалось загрузить, а также те из них, смещение которых в
+// указанный момент времени попадает в интервалы часовых поясов.
+func (p *Provider) getTimezoneNames(
+	ctx context.Context,
+	ranges []timezone.Range,
+	now time.Time,
+) (valid []string, matched []string, err *customerror.ServiceError) {
+	valid, err = p.getValidTimezoneNames(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, name := range valid {
+		loc, e := timezone.LoadLocation(name)
+		if e != nil {
+			continue
+		}
+		offset := timezone.At(loc, now)
+
+		for _, r := range ranges {
+			if r.ContainsTimezone(offset) {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	return valid, matched, nil
+}
+
+// Возвращает наименования часовых поясов IANA, указанных у пользователей,
+// которые удалось загрузить. Список запрашивается из БД не чаще, чем раз в
+// timezoneNamesTTL, так как метод вызывается для каждой порции
+// пользователей.
+func (p *Provider) getValidTimezoneNames(ctx context.Context) ([]string, *customerror.ServiceError) {
+	p.timezoneNamesMu.Lock()
+	defer p.timezoneNamesMu.Unlock()
+
+	if !p.timezoneNamesUpdatedAt.IsZero() && time.Since(p.timezoneNamesUpdatedAt) < timezoneNamesTTL {
+		return p.timezoneNames, nil
+	}
+
+	names, err := p.getUsersCollection().Distinct(ctx, "timezoneName", bson.M{})
+	if err != nil {
+		return nil, newServiceError(err)
+	}
+	valid := make([]string, 0, len(names))
+
+	for _, n := range names {
+		name, ok := n.(string)
+		if !ok || name == "" {
+			continue
+		}
+		if _, err := timezone.LoadLocation(name); err != nil {
+			continue
+		}
+		valid = append(valid, name)
+	}
+	p.timezoneNames = valid
+	p.timezoneNamesUpdatedAt = time.Now()
+
+	return valid, nil
+}
+
 func (p *Provider) SetAllowStatusForUser(
+	ctx context.Context,
 	userId user.Id,
 	appId appid.Id,
 	allowed bool,
@@ -94,6 +256,7 @@ func (p *Provider) SetAllowStatusForUser(
 		UserId(userId),
 		Apps{mongoAppId: App{AreNotificationsEnabled: allowed}},
 		0,
+		"",
 	)
 
 	// Создаем пэйлоад для обновления.
@@ -104,18 +267,18 @@ func (p *Provider) SetAllowStatusForUser(
 
 	// Если пользователь указан, добавляем его в update payload.
 	if user != nil {
-		updatePayload["$setOnInsert"] = NewUser(u.Id, nil, int(user.Timezone))
+		updatePayload["$setOnInsert"] = NewUser(u.Id, nil, int(user.Timezone), user.Location)
 		updateOptions.SetUpsert(true)
 	}
 
 	res, err := p.getUsersCollection().UpdateByID(
-		context.Background(),
+		ctx,
 		userId,
 		updatePayload,
 		updateOptions,
 	)
 	if err != nil {
-		return customerror.NewServiceError(err)
+		return newServiceError(err)
 	}
 	if res.MatchedCount == 0 && user == nil {
 		return customerror.NewServiceError(providers.ErrUserDoesNotExist)
@@ -124,69 +287,294 @@ func (p *Provider) SetAllowStatusForUser(
 }
 
 func (p *Provider) SaveSendResult(
+	ctx context.Context,
 	results *notification.SendResult,
+	params []notification.Params,
 	appId appid.Id,
 	taskId taskid.Id,
 	date time.Time,
+	historyLimit uint,
+) *customerror.ServiceError {
+	// Задача может только увеличить длину истории, так как история всех
+	// задач приложения используется для проверки общих ограничений.
+	if historyLimit < p.historyLimit {
+		historyLimit = p.historyLimit
+	}
+	var models []mongo.WriteModel
+	// Разделы результата отправки, к которым относятся операции. Индекс
+	// раздела совпадает с индексом операции.
+	var buckets []string
+
+	addModel := func(bucket string, userId user.Id, update bson.M) {
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(bson.M{"_id": userId}).SetUpdate(update))
+		buckets = append(buckets, bucket)
+	}
+
+	// Добавляем в историю пользователей, которым удалось отправить
+	// уведомление, запись об отправке вместе с фрагментом уведомления, а
+	// также увеличиваем счетчик отправок и обновляем дату последней отправки.
+	taskPath := fmt.Sprintf("apps.%d.tasks.%d", appId, taskId)
+	fragments := make(map[user.Id]string, len(params))
+	for _, p := range params {
+		fragments[p.UserId] = p.Fragment
+	}
+	for _, uid := range results.Success {
+		addModel(providers.BucketSuccess, uid, bson.M{
+			"$push": bson.M{taskPath + ".history": bson.M{
+				"$each":     []HistoryItem{{Date: date, Fragment: fragments[uid]}},
+				"$position": 0,
+				"$slice":    historyLimit,
+			}},
+			"$inc": bson.M{taskPath + ".sendCount": 1},
+			"$max": bson.M{taskPath + ".lastSentAt": date},
+		})
+	}
+
+	// Запрещаем отправку уведомлений пользователям, которые их отключили.
+	enabledPath := fmt.Sprintf("apps.%d.areNotificationsEnabled", appId)
+	for _, uid := range results.NotificationsDisabled {
+		addModel(providers.BucketNotificationsDisabled, uid, bson.M{
+			"$set": bson.M{enabledPath: false},
+		})
+	}
+
+	// Обновляем пользователей, у которых достигнут лимит на отправку
+	// уведомлений. До окончания ограничения они не будут выбираться для
+	// отправки уведомлений этого приложения.
+	cooldownPath := fmt.Sprintf("apps.%d.cooldownUntil", appId)
+	for _, uid := range results.HourRateLimitReached {
+		addModel(providers.BucketHourRateLimitReached, uid, bson.M{
+			"$max": bson.M{cooldownPath: date.Add(notification.HourRateLimitCooldown)},
+		})
+	}
+	for _, uid := range results.DayRateLimitReached {
+		addModel(providers.BucketDayRateLimitReached, uid, bson.M{
+			"$max": bson.M{cooldownPath: date.Add(notification.DayRateLimitCooldown)},
+		})
+	}
+
+	if len(models) == 0 {
+		return nil
+	}
+
+	_, err := p.
+		getUsersCollection().
+		BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+	if err == nil {
+		return nil
+	}
+
+	// Определяем разделы результата, сохранить которые не удалось. Если
+	// ошибка не относится к конкретным операциям, считаем неудачными все
+	// разделы.
+	failed := make(map[string]int)
+	var bulkErr mongo.BulkWriteException
+
+	if errors.As(err, &bulkErr) && len(bulkErr.WriteErrors) > 0 {
+		for _, e := range bulkErr.WriteErrors {
+			failed[buckets[e.Index]]++
+		}
+	} else {
+		for _, b := range buckets {
+			failed[b]++
+		}
+	}
+
+	serviceErr := newServiceError(err)
+	serviceErr.Original = providers.NewSaveSendResultError(failed, err)
+
+	return serviceErr
+}
+
+func (p *Provider) SaveCheckpoint(
+	ctx context.Context,
+	c *checkpoint.Checkpoint,
 ) *customerror.ServiceError {
-	// TODO: Возможно это сделать в виде агрегации?
-	// TODO: Возвращать этот массив ошибок.
-	// TODO: Выполнять все обновления в отдельных горутинах?
-	var errs []customerror.ServiceError
-
-	// Обновляем пользователей, которым удалось отправить уведомление.
-	if results.Success != nil && len(results.Success) > 0 {
-		path := fmt.Sprintf("apps.%d.tasks.%d.history", appId, taskId)
-		_, err := p.
-			getUsersCollection().
-			UpdateMany(
-				context.Background(),
-				bson.M{"_id": bson.M{"$in": results.Success}},
-				bson.M{
-					"$push": bson.D{{path, bson.M{
-						"$each":     []time.Time{date},
-						"$position": 0,
-						"$slice":    15, // TODO: Вынести в опцию?
-					}}},
-				},
-			)
-		if err != nil {
-			errs = append(errs, *customerror.NewServiceError(err))
+	id := primitive.NewObjectID()
+
+	if c.Id != "" {
+		var err error
+		if id, err = primitive.ObjectIDFromHex(c.Id); err != nil {
+			return customerror.NewServiceError(err)
 		}
 	}
 
-	// Обновляем пользователей, уведомления которым запрещены.
-	if results.NotificationsDisabled != nil && len(results.NotificationsDisabled) > 0 {
-		path := fmt.Sprintf("apps.%d.areNotificationsEnabled", appId)
-		_, err := p.
-			getUsersCollection().
-			UpdateMany(
-				context.Background(),
-				bson.M{"_id": bson.M{"$in": results.Success}},
-				bson.D{{path, false}},
-			)
-		if err != nil {
-			errs = append(errs, *customerror.NewServiceError(err))
+	_, err := p.getCheckpointsCollection().ReplaceOne(
+		ctx,
+		bson.M{"_id": id},
+		NewCheckpoint(id, c),
+		options.Replace().SetUpsert(true),
+	)
+	if err != nil {
+		return newServiceError(err)
+	}
+	c.Id = id.Hex()
+
+	return nil
+}
+
+func (p *Provider) GetUnfinishedCheckpoint(
+	ctx context.Context,
+	since time.Time,
+) (*checkpoint.Checkpoint, *customerror.ServiceError) {
+	var c Checkpoint
+
+	err := p.
+		getCheckpointsCollection().
+		FindOne(
+			ctx,
+			bson.M{
+				"status": bson.M{"$in": []checkpoint.Status{
+					checkpoint.StatusRunning,
+					checkpoint.StatusInterrupted,
+				}},
+				"updatedAt": bson.M{"$gte": since},
+			},
+			options.FindOne().SetSort(bson.D{{Key: "updatedAt", Value: -1}}),
+		).
+		Decode(&c)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, newServiceError(err)
+	}
+	return c.ToCommon(), nil
+}
+
+func (p *Provider) SaveRetryItems(
+	ctx context.Context,
+	items []retry.Item,
+) *customerror.ServiceError {
+	if len(items) == 0 {
+		return nil
+	}
+	models := make([]mongo.WriteModel, len(items))
+
+	for i := range items {
+		id := primitive.NewObjectID()
+
+		if items[i].Id != "" {
+			var err error
+			if id, err = primitive.ObjectIDFromHex(items[i].Id); err != nil {
+				return customerror.NewServiceError(err)
+			}
 		}
+		models[i] = mongo.
+			NewReplaceOneModel().
+			SetFilter(bson.M{"_id": id}).
+			SetReplacement(NewRetryItem(id, &items[i])).
+			SetUpsert(true)
+		items[i].Id = id.Hex()
 	}
 
-	// TODO: Обновить пользователей, у которых кулдаун на отправку уведомления.
+	_, err := p.getRetriesCollection().BulkWrite(ctx, models)
+	if err != nil {
+		return newServiceError(err)
+	}
+	return nil
+}
+
+func (p *Provider) GetDueRetryItems(
+	ctx context.Context,
+	now time.Time,
+	limit int64,
+) ([]retry.Item, *customerror.ServiceError) {
+	return p.findRetryItems(
+		ctx,
+		bson.M{"nextAttemptAt": bson.M{"$lte": now}},
+		options.Find().SetSort(bson.D{{Key: "nextAttemptAt", Value: 1}}).SetLimit(limit),
+	)
+}
 
+func (p *Provider) GetRetryItemsByUsers(
+	ctx context.Context,
+	userIds []user.Id,
+) ([]retry.Item, *customerror.ServiceError) {
+	if len(userIds) == 0 {
+		return nil, nil
+	}
+	return p.findRetryItems(ctx, bson.M{"userId": bson.M{"$in": userIds}}, options.Find())
+}
+
+func (p *Provider) DeleteRetryItems(
+	ctx context.Context,
+	ids []string,
+) *customerror.ServiceError {
+	if len(ids) == 0 {
+		return nil
+	}
+	objectIds := make([]primitive.ObjectID, len(ids))
+
+	for i, id := range ids {
+		var err error
+		if objectIds[i], err = primitive.ObjectIDFromHex(id); err != nil {
+			return customerror.NewServiceError(err)
+		}
+	}
+
+	_, err := p.getRetriesCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": objectIds}})
+	if err != nil {
+		return newServiceError(err)
+	}
 	return nil
 }
 
+// Возвращает записи об уведомлениях для повторной отправки, удовлетворяющие
+// фильтру.
+func (p *Provider) findRetryItems(
+	ctx context.Context,
+	filter bson.M,
+	opts *options.FindOptions,
+) ([]retry.Item, *customerror.ServiceError) {
+	cur, err := p.getRetriesCollection().Find(ctx, filter, opts)
+	if err != nil {
+		return nil, newServiceError(err)
+	}
+	defer cur.Close(ctx)
+
+	var items []retry.Item
+
+	for cur.Next(ctx) {
+		var item RetryItem
+
+		if err := cur.Decode(&item); err != nil {
+			return nil, newServiceError(err)
+		}
+		items = append(items, *item.ToCommon())
+	}
+	if err := cur.Err(); err != nil {
+		return nil, newServiceError(err)
+	}
+	return items, nil
+}
+
 // Возвращает коллекцию пользователей.
 func (p *Provider) getUsersCollection() *mongo.Collection {
 	return p.client.Database(p.db).Collection("users")
 }
 
+// Возвращает коллекцию уведомлений для повторной отправки.
+func (p *Provider) getRetriesCollection() *mongo.Collection {
+	return p.client.Database(p.db).Collection("retries")
+}
+
+// Возвращает коллекцию записей об итерациях сервиса.
+func (p *Provider) getCheckpointsCollection() *mongo.Collection {
+	return p.client.Database(p.db).Collection("checkpoints")
+}
+
 // New возвращает новый экземпляр драйвера для работы с MongoDB.
 func New(
 	host string,
 	port uint,
 	db string,
 	getUsersByTimezonesLimit int64,
+	opts NewOptions,
 ) (providers.Provider, error) {
+	if opts.HistoryLimit == 0 {
+		opts.HistoryLimit = DefaultHistoryLimit
+	}
 	connString := fmt.Sprintf("mongodb://%s:%d", host, port)
 	// TODO: Возможно стоит передавать контекст с таймаутом.
 	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(connString))
@@ -194,9 +582,41 @@ func New(
 		return nil, err
 	}
 
-	return &Provider{
+	p := &Provider{
 		client:                   client,
 		db:                       db,
 		getUsersByTimezonesLimit: getUsersByTimezonesLimit,
-	}, nil
+		historyLimit:             opts.HistoryLimit,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), createIndexesTimeout)
+	defer cancel()
+
+	if err := p.createIndexes(ctx); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Создает индексы, необходимые для работы провайдера.
+func (p *Provider) createIndexes(ctx context.Context) error {
+	// Записи об итерациях выбираются по состоянию и дате обновления, а
+	// старые записи автоматически удаляются.
+	_, err := p.getCheckpointsCollection().Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "updatedAt", Value: -1}}},
+		{
+			Keys:    bson.D{{Key: "updatedAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(checkpointsTTL.Seconds())),
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Список часовых поясов IANA пользователей получается по индексу, он же
+	// используется при выборке пользователей по часовому поясу.
+	_, err = p.getUsersCollection().Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "timezoneName", Value: 1}},
+	})
+	return err
 }
@@ -0,0 +1,283 @@
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/providers"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAppId  = 1
+	testTaskId = 2
+)
+
+// Результат проверки доступности MongoDB.
+var availability struct {
+	once sync.Once
+	err  error
+}
+
+// Создает провайдер, работающий с отдельной тестовой БД, которая удаляется
+// по завершении теста. В случае, если MongoDB недоступна, тест пропускается.
+// Адрес MongoDB задается переменными окружения MONGODB_HOST и MONGODB_PORT.
+func newTestProvider(t *testing.T, opts NewOptions) *Provider {
+	t.Helper()
+
+	host := os.Getenv("MONGODB_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := uint64(27017)
+	if v := os.Getenv("MONGODB_PORT"); v != "" {
+		var err error
+		if port, err = strconv.ParseUint(v, 10, 16); err != nil {
+			t.Fatalf("некорректный MONGODB_PORT: %v", err)
+		}
+	}
+
+	// Проверяем доступность MongoDB один раз, не дожидаясь стандартного
+	// таймаута.
+	uri := fmt.Sprintf("mongodb://%s:%d", host, port)
+	availability.once.Do(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetServerSelectionTimeout(2*time.Second))
+		if err == nil {
+			err = client.Ping(ctx, nil)
+			_ = client.Disconnect(context.Background())
+		}
+		availability.err = err
+	})
+	if availability.err != nil {
+		t.Skipf("MongoDB недоступна по адресу %s: %v", uri, availability.err)
+	}
+
+	db := fmt.Sprintf("notifications-service-test-%d", time.Now().UnixNano())
+	p, err := New(host, uint(port), db, 100, opts)
+	if err != nil {
+		t.Fatalf("не удалось создать провайдер: %v", err)
+	}
+	provider := p.(*Provider)
+
+	t.Cleanup(func() {
+		_ = provider.client.Database(db).Drop(context.Background())
+		_ = provider.client.Disconnect(context.Background())
+	})
+	return provider
+}
+
+// Добавляет пользователей, разрешивших отправку уведомлений тестового
+// приложения.
+func insertUsers(t *testing.T, p *Provider, ids ...user.Id) {
+	t.Helper()
+
+	for _, id := range ids {
+		u := NewUser(UserId(id), Apps{testAppId: App{AreNotificationsEnabled: true}}, 0, "")
+
+		if _, err := p.getUsersCollection().InsertOne(context.Background(), u); err != nil {
+			t.Fatalf("не удалось добавить пользователя %d: %v", id, err)
+		}
+	}
+}
+
+// Возвращает пользователя из БД.
+func findUser(t *testing.T, p *Provider, id user.Id) *User {
+	t.Helper()
+
+	var u User
+	if err := p.getUsersCollection().FindOne(context.Background(), bson.M{"_id": id}).Decode(&u); err != nil {
+		t.Fatalf("не удалось получить пользователя %d: %v", id, err)
+	}
+	return &u
+}
+
+// Возвращает тестовую дату, смещенную на указанное количество минут. Дата
+// не содержит долей секунды, поэтому сохраняется в MongoDB без потери
+// точности.
+func date(minutes int) time.Time {
+	return time.Date(2026, 11, 1, 19, 0, 0, 0, time.UTC).Add(time.Duration(minutes) * time.Minute)
+}
+
+func TestSaveSendResultSuccess(t *testing.T) {
+	p := newTestProvider(t, NewOptions{HistoryLimit: 2})
+	insertUsers(t, p, 1)
+
+	ctx := context.Background()
+	dates := []time.Time{date(0), date(10), date(20)}
+
+	for i, d := range dates {
+		params := []notification.Params{{UserId: 1, Message: "message", Fragment: strconv.Itoa(i)}}
+		res := &notification.SendResult{Success: []user.Id{1}}
+
+		if err := p.SaveSendResult(ctx, res, params, testAppId, testTaskId, d, 0); err != nil {
+			t.Fatalf("неожиданная ошибка: %v", err.Original)
+		}
+	}
+
+	task := findUser(t, p, 1).Apps[testAppId].Tasks[testTaskId]
+
+	if task.SendCount != uint(len(dates)) {
+		t.Errorf("ожидалось %d отправок, получено %d", len(dates), task.SendCount)
+	}
+	if !task.LastSentAt.Equal(dates[2]) {
+		t.Errorf("ожидалась дата последней отправки %v, получено %v", dates[2], task.LastSentAt)
+	}
+	// История ограничена двумя последними отправками, последняя в начале.
+	expected := []HistoryItem{{Date: dates[2], Fragment: "2"}, {Date: dates[1], Fragment: "1"}}
+	if len(task.History) != len(expected) {
+		t.Fatalf("ожидалось %d записей истории, получено %d", len(expected), len(task.History))
+	}
+	for i, h := range expected {
+		if !task.History[i].Date.Equal(h.Date) || task.History[i].Fragment != h.Fragment {
+			t.Errorf("запись истории %d: ожидалось %+v, получено %+v", i, h, task.History[i])
+		}
+	}
+}
+
+func TestSaveSendResultDisabled(t *testing.T) {
+	p := newTestProvider(t, NewOptions{})
+	insertUsers(t, p, 1, 2)
+
+	res := &notification.SendResult{NotificationsDisabled: []user.Id{1}}
+	if err := p.SaveSendResult(context.Background(), res, nil, testAppId, testTaskId, date(0), 0); err != nil {
+		t.Fatalf("неожиданная ошибка: %v", err.Original)
+	}
+
+	if findUser(t, p, 1).Apps[testAppId].AreNotificationsEnabled {
+		t.Error("пользователю 1 должна быть запрещена отправка уведомлений")
+	}
+	if !findUser(t, p, 2).Apps[testAppId].AreNotificationsEnabled {
+		t.Error("пользователю 2 отправка уведомлений должна остаться разрешенной")
+	}
+}
+
+func TestSaveSendResultCooldown(t *testing.T) {
+	p := newTestProvider(t, NewOptions{})
+	insertUsers(t, p, 1, 2)
+
+	ctx := context.Background()
+	res := &notification.SendResult{
+		HourRateLimitReached: []user.Id{1},
+		DayRateLimitReached:  []user.Id{2},
+	}
+	if err := p.SaveSendResult(ctx, res, nil, testAppId, testTaskId, date(0), 0); err != nil {
+		t.Fatalf("неожиданная ошибка: %v", err.Original)
+	}
+
+	// Более раннее ограничение не должно сокращать уже действующее.
+	res = &notification.SendResult{HourRateLimitReached: []user.Id{2}}
+	if err := p.SaveSendResult(ctx, res, nil, testAppId, testTaskId, date(10), 0); err != nil {
+		t.Fatalf("неожиданная ошибка: %v", err.Original)
+	}
+
+	tests := []struct {
+		userId   user.Id
+		expected time.Time
+	}{
+		{userId: 1, expected: date(0).Add(notification.HourRateLimitCooldown)},
+		{userId: 2, expected: date(0).Add(notification.DayRateLimitCooldown)},
+	}
+	for _, tt := range tests {
+		if got := findUser(t, p, tt.userId).Apps[testAppId].CooldownUntil; !got.Equal(tt.expected) {
+			t.Errorf("пользователь %d: ожидалось ограничение до %v, получено %v", tt.userId, tt.expected, got)
+		}
+	}
+}
+
+func TestSaveSendResultPartialFailure(t *testing.T) {
+	p := newTestProvider(t, NewOptions{})
+	insertUsers(t, p, 1, 2)
+
+	// У пользователя 3 поле apps не является документом, поэтому любые
+	// обновления его приложений завершаются ошибкой.
+	_, err := p.getUsersCollection().InsertOne(context.Background(), bson.M{"_id": 3, "apps": "broken", "timezone": 0})
+	if err != nil {
+		t.Fatalf("не удалось добавить пользователя: %v", err)
+	}
+
+	res := &notification.SendResult{
+		Success:               []user.Id{1, 3},
+		NotificationsDisabled: []user.Id{2, 3},
+		DayRateLimitReached:   []user.Id{3},
+	}
+	params := []notification.Params{{UserId: 1, Message: "message"}, {UserId: 3, Message: "message"}}
+
+	serviceErr := p.SaveSendResult(context.Background(), res, params, testAppId, testTaskId, date(0), 0)
+	if serviceErr == nil {
+		t.Fatal("ожидалась ошибка сохранения")
+	}
+
+	var saveErr *providers.SaveSendResultError
+	if !errors.As(serviceErr.Original, &saveErr) {
+		t.Fatalf("ожидалась ошибка SaveSendResultError, получено %T", serviceErr.Original)
+	}
+	expected := map[string]int{
+		providers.BucketSuccess:               1,
+		providers.BucketNotificationsDisabled: 1,
+		providers.BucketDayRateLimitReached:   1,
+	}
+	if len(saveErr.FailedBuckets) != len(expected) {
+		t.Errorf("ожидались разделы %v, получено %v", expected, saveErr.FailedBuckets)
+	}
+	for bucket, count := range expected {
+		if saveErr.FailedBuckets[bucket] != count {
+			t.Errorf("раздел %s: ожидалось %d ошибок, получено %d", bucket, count, saveErr.FailedBuckets[bucket])
+		}
+	}
+
+	// Операции для остальных пользователей должны быть выполнены.
+	if findUser(t, p, 1).Apps[testAppId].Tasks[testTaskId].SendCount != 1 {
+		t.Error("отправка пользователю 1 должна быть сохранена")
+	}
+	if findUser(t, p, 2).Apps[testAppId].AreNotificationsEnabled {
+		t.Error("пользователю 2 должна быть запрещена отправка уведомлений")
+	}
+}
+
+func TestSaveSendResultHistoryLimit(t *testing.T) {
+	tests := []struct {
+		name string
+		// Длина истории, запрошенная задачей.
+		requested uint
+		// Ожидаемая длина истории.
+		expected int
+	}{
+		{name: "ограничение провайдера", requested: 0, expected: 2},
+		{name: "меньше ограничения провайдера", requested: 1, expected: 2},
+		{name: "больше ограничения провайдера", requested: 3, expected: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTestProvider(t, NewOptions{HistoryLimit: 2})
+			insertUsers(t, p, 1)
+
+			for i := 0; i < 4; i++ {
+				params := []notification.Params{{UserId: 1, Message: "message"}}
+				res := &notification.SendResult{Success: []user.Id{1}}
+
+				err := p.SaveSendResult(context.Background(), res, params, testAppId, testTaskId, date(i), tt.requested)
+				if err != nil {
+					t.Fatalf("неожиданная ошибка: %v", err.Original)
+				}
+			}
+
+			task := findUser(t, p, 1).Apps[testAppId].Tasks[testTaskId]
+			if len(task.History) != tt.expected {
+				t.Errorf("ожидалось %d записей истории, получено %d", tt.expected, len(task.History))
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package mongodb
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"time"
+)
+
+type RetryItem struct {
+	// Идентификатор записи.
+	Id primitive.ObjectID `bson:"_id"`
+	// Идентификатор пользователя.
+	UserId UserId `bson:"userId"`
+	// Идентификатор приложения-владельца задачи.
+	AppId AppId `bson:"appId"`
+	// Идентификатор задачи.
+	TaskId TaskId `bson:"taskId"`
+	// Текст уведомления.
+	Message string `bson:"message"`
+	// Фрагмент уведомления.
+	Fragment string `bson:"fragment,omitempty"`
+	// Количество выполненных попыток отправки.
+	Attempts int `bson:"attempts"`
+	// Дата, начиная с которой можно выполнить следующую попытку.
+	NextAttemptAt time.Time `bson:"nextAttemptAt"`
+	// Дата, после которой отправка уведомления теряет смысл.
+	ExpiresAt time.Time `bson:"expiresAt"`
+	// Исчерпаны ли попытки отправки.
+	Dead bool `bson:"dead,omitempty"`
+}
+
+// ToCommon конвертирует текущую запись к общему виду.
+func (r *RetryItem) ToCommon() *retry.Item {
+	return &retry.Item{
+		Id:            r.Id.Hex(),
+		UserId:        user.Id(r.UserId),
+		AppId:         appid.Id(r.AppId),
+		TaskId:        taskid.Id(r.TaskId),
+		Message:       r.Message,
+		Fragment:      r.Fragment,
+		Attempts:      r.Attempts,
+		NextAttemptAt: r.NextAttemptAt,
+		ExpiresAt:     r.ExpiresAt,
+		Dead:          r.Dead,
+	}
+}
+
+// NewRetryItem создает ссылку на новый экземпляр RetryItem из записи общего
+// вида.
+func NewRetryItem(id primitive.ObjectID, item *retry.Item) *RetryItem {
+	return &RetryItem{
+		Id:            id,
+		UserId:        UserId(item.UserId),
+		AppId:         AppId(item.AppId),
+		TaskId:        TaskId(item.TaskId),
+		Message:       item.Message,
+		Fragment:      item.Fragment,
+		Attempts:      item.Attempts,
+		NextAttemptAt: item.NextAttemptAt,
+		ExpiresAt:     item.ExpiresAt,
+		Dead:          item.Dead,
+	}
+}
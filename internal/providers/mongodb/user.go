@@ -2,9 +2,12 @@ package mongodb
 
 import (
 	"fmt"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/timezone"
 	"github.com/wolframdeus/noitifications-service/internal/user"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"time"
 )
 
@@ -13,11 +16,53 @@ type UserId uint64
 
 type TaskId uint64
 
+// HistoryItem описывает запись в истории отправки уведомления.
+type HistoryItem struct {
+	// Дата отправки уведомления.
+	Date time.Time `bson:"date"`
+	// Фрагмент, который был использован в уведомлении.
+	Fragment string `bson:"fragment,omitempty"`
+}
+
+// UnmarshalBSONValue декодирует запись истории. Ранее в истории хранились
+// только даты отправки, поэтому такие значения также поддерживаются.
+func (h *HistoryItem) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t == bsontype.DateTime {
+		*h = HistoryItem{Date: bson.RawValue{Type: t, Value: data}.Time()}
+		return nil
+	}
+	type historyItem HistoryItem
+
+	return bson.Unmarshal(data, (*historyItem)(h))
+}
+
 type Task struct {
 	// Количество отправок этого уведомления пользователю.
 	SendCount uint `bson:"sendCount"`
-	// История отправки этого уведомления.
-	History []time.Time `bson:"history"`
+	// Дата последней отправки этого уведомления пользователю.
+	LastSentAt time.Time `bson:"lastSentAt,omitempty"`
+	// История отправки этого уведомления. Последняя отправка находится в
+	// начале списка.
+	History []HistoryItem `bson:"history"`
+}
+
+// ToCommon конвертирует текущую задачу к общему виду.
+func (t *Task) ToCommon() user.Task {
+	res := user.Task{
+		SendCount:  t.SendCount,
+		LastSentAt: t.LastSentAt,
+		History:    make([]user.HistoryItem, len(t.History)),
+	}
+
+	for i, h := range t.History {
+		res.History[i] = user.HistoryItem{SentAt: h.Date, Fragment: h.Fragment}
+	}
+	// Ранее дата последней отправки не сохранялась отдельно, в этом случае
+	// берём её из истории.
+	if res.LastSentAt.IsZero() && len(t.History) > 0 {
+		res.LastSentAt = t.History[0].Date
+	}
+	return res
 }
 
 // Tasks описывает карту с информацией о каких-либо уведомлениях пользователя.
@@ -28,20 +73,47 @@ type AppId uint64
 type App struct {
 	// Разрешена ли пользователю отправка уведомлений в этом приложении.
 	AreNotificationsEnabled bool `bson:"areNotificationsEnabled"`
+	// Дата, до которой пользователю не отправляются уведомления этого
+	// приложения ввиду достижения лимита на их отправку.
+	CooldownUntil time.Time `bson:"cooldownUntil,omitempty"`
 	// Информация об уведомлениях от этого приложения.
 	Tasks Tasks `bson:"tasks"`
 }
 
+// ToCommon конвертирует текущее приложение к общему виду.
+func (a *App) ToCommon() user.App {
+	res := user.App{
+		AreNotificationsEnabled: a.AreNotificationsEnabled,
+		CooldownUntil:           a.CooldownUntil,
+		Tasks:                   make(map[taskid.Id]user.Task, len(a.Tasks)),
+	}
+
+	for id, t := range a.Tasks {
+		res.Tasks[taskid.Id(id)] = t.ToCommon()
+	}
+	return res
+}
+
 // Apps описывает карту с информацией о каких-либо приложениях пользователя.
 type Apps map[AppId]App
 
+// ToCommon конвертирует текущую карту приложений к общему виду.
+func (a *Apps) ToCommon() map[appid.Id]user.App {
+	res := make(map[appid.Id]user.App, len(*a))
+
+	for id, app := range *a {
+		res[appid.Id(id)] = app.ToCommon()
+	}
+	return res
+}
+
 // GetAppNotificationsEnabledUpdatePayload возвращает пэйлоад обновления
 // разрешения на отправку уведомления для указанного приложения.
 func (a *Apps) GetAppNotificationsEnabledUpdatePayload(appId AppId) bson.D {
 	app, _ := (*a)[appId]
 	path := fmt.Sprintf("apps.%d.areNotificationsEnabled", appId)
 
-	return bson.D{{path, app.AreNotificationsEnabled}}
+	return bson.D{{Key: path, Value: app.AreNotificationsEnabled}}
 }
 
 type User struct {
@@ -53,6 +125,11 @@ type User struct {
 	// необходимо прибавить ко времени по Гринвичу, чтобы получить локальное
 	// время.
 	Timezone int `bson:"timezone"`
+	// Наименование часового пояса IANA пользователя. В случае, если указано,
+	// локальное время пользователя вычисляется с учетом перехода на летнее
+	// время, а Timezone используется только при невозможности загрузить
+	// часовой пояс.
+	TimezoneName string `bson:"timezoneName,omitempty"`
 }
 
 // ToCommon конвертирует текущего пользователя к общему виду.
@@ -60,10 +137,12 @@ func (u *User) ToCommon() *user.User {
 	return &user.User{
 		Id:       user.Id(u.Id),
 		Timezone: timezone.Timezone(u.Timezone),
+		Location: u.TimezoneName,
+		Apps:     u.Apps.ToCommon(),
 	}
 }
 
 // NewUser создает ссылку на новый экземпляр User.
-func NewUser(id UserId, apps Apps, timezone int) *User {
-	return &User{Id: id, Apps: apps, Timezone: timezone}
+func NewUser(id UserId, apps Apps, timezone int, timezoneName string) *User {
+	return &User{Id: id, Apps: apps, Timezone: timezone, TimezoneName: timezoneName}
 }
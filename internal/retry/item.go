@@ -0,0 +1,56 @@
+package retry
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+// Item описывает уведомление, отправку которого необходимо повторить ввиду
+// временной ошибки.
+type Item struct {
+	// Идентификатор записи. Устанавливается провайдером при первом
+	// сохранении.
+	Id string
+	// Идентификатор пользователя.
+	UserId user.Id
+	// Идентификатор приложения-владельца задачи.
+	AppId appid.Id
+	// Идентификатор задачи.
+	TaskId taskid.Id
+	// Текст уведомления.
+	Message string
+	// Фрагмент уведомления.
+	Fragment string
+	// Количество выполненных попыток отправки.
+	Attempts int
+	// Дата, начиная с которой можно выполнить следующую попытку.
+	NextAttemptAt time.Time
+	// Дата, после которой отправка уведомления теряет смысл, так как
+	// промежуток отправки задачи для пользователя завершился.
+	ExpiresAt time.Time
+	// Исчерпаны ли попытки отправки. Такое уведомление больше не
+	// отправляется, но до ExpiresAt исключает пользователя из обычной
+	// выборки задачи, чтобы попытки не начались заново.
+	Dead bool
+}
+
+// NewItem возвращает ссылку на новый экземпляр Item.
+func NewItem(
+	userId user.Id,
+	appId appid.Id,
+	taskId taskid.Id,
+	message string,
+	fragment string,
+	expiresAt time.Time,
+) *Item {
+	return &Item{
+		UserId:    userId,
+		AppId:     appId,
+		TaskId:    taskId,
+		Message:   message,
+		Fragment:  fragment,
+		ExpiresAt: expiresAt,
+	}
+}
@@ -0,0 +1,51 @@
+package retry
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy описывает политику повторного выполнения операций.
+type Policy struct {
+	// Максимальное количество попыток выполнения операции, включая первую.
+	MaxAttempts int
+	// Задержка перед второй попыткой.
+	InitialDelay time.Duration
+	// Максимальная задержка между попытками.
+	MaxDelay time.Duration
+	// Множитель, на который увеличивается задержка после каждой попытки.
+	Multiplier float64
+	// Доля задержки, на которую она может быть случайно изменена в большую
+	// или меньшую сторону. Допустимые значения: от 0 до 1.
+	Jitter float64
+}
+
+// Delay возвращает задержку перед попыткой с указанным номером. Нумерация
+// попыток начинается с 1, перед первой попыткой задержки нет.
+func (p *Policy) Delay(attempt int) time.Duration {
+	if attempt <= 1 {
+		return 0
+	}
+	delay := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(attempt-2))
+
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
+
+// NewDefaultPolicy возвращает ссылку на политику повторного выполнения
+// операций по умолчанию.
+func NewDefaultPolicy() *Policy {
+	return &Policy{
+		MaxAttempts:  5,
+		InitialDelay: 500 * time.Millisecond,
+		MaxDelay:     15 * time.Second,
+		Multiplier:   2,
+		Jitter:       0.2,
+	}
+}
@@ -0,0 +1,7 @@
+package senders
+
+import "errors"
+
+var (
+	ErrAppNotSupported = errors.New("отправка уведомлений от лица приложения не поддерживается")
+)
@@ -0,0 +1,23 @@
+package senders
+
+import (
+	"context"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+)
+
+// Sender описывает способ доставки уведомлений пользователям.
+type Sender interface {
+	// CanSend возвращает true в случае, если отправка уведомлений от лица
+	// указанного приложения поддерживается.
+	CanSend(appId appid.Id) bool
+
+	// Send выполняет отправку уведомлений пользователям от лица указанного
+	// приложения и возвращает результаты отправки.
+	Send(
+		ctx context.Context,
+		appId appid.Id,
+		params []notification.Params,
+	) (*notification.SendResult, *customerror.ServiceError)
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"sync"
+)
+
+// Sender хранит все отправленные уведомления в памяти. Используется в
+// тестах, а также при локальной разработке.
+type Sender struct {
+	mu sync.Mutex
+	// Список всех отправленных уведомлений в порядке их отправки, разбитый по
+	// приложениям.
+	sent map[appid.Id][]notification.Params
+}
+
+// CanSend всегда возвращает true, так как сохранение уведомлений в памяти
+// возможно для любого приложения.
+func (s *Sender) CanSend(appid.Id) bool {
+	return true
+}
+
+// Send запоминает переданные уведомления и считает их успешно
+// отправленными.
+func (s *Sender) Send(
+	ctx context.Context,
+	appId appid.Id,
+	params []notification.Params,
+) (*notification.SendResult, *customerror.ServiceError) {
+	if err := ctx.Err(); err != nil {
+		return nil, customerror.NewServiceError(err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &notification.SendResult{}
+
+	for _, p := range params {
+		s.sent[appId] = append(s.sent[appId], p)
+		result.Success = append(result.Success, p.UserId)
+	}
+	return result, nil
+}
+
+// Sent возвращает копию списка всех уведомлений, отправленных от лица
+// указанного приложения.
+func (s *Sender) Sent(appId appid.Id) []notification.Params {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	res := make([]notification.Params, len(s.sent[appId]))
+	copy(res, s.sent[appId])
+	return res
+}
+
+// Reset очищает список отправленных уведомлений.
+func (s *Sender) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sent = make(map[appid.Id][]notification.Params)
+}
+
+// New возвращает ссылку на новый экземпляр Sender.
+func New() *Sender {
+	return &Sender{sent: make(map[appid.Id][]notification.Params)}
+}
@@ -0,0 +1,7 @@
+package vk
+
+import "errors"
+
+var (
+	ErrUnknownUserStatus = errors.New("неизвестный результат отправки уведомления пользователю")
+)
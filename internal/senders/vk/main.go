@@ -0,0 +1,322 @@
+package vk
+
+import (
+	"context"
+	"errors"
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/senders"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	// SendNotificationUsersLimit - максимальное количество пользователей,
+	// которым за раз можно отправить уведомление.
+	SendNotificationUsersLimit = 100
+	// MessageMaxLength - максимальная длина сообщения уведомления в
+	// символах. Более длинные сообщения обрезаются.
+	MessageMaxLength = 256
+	// DefaultConcurrency - количество одновременно выполняемых запросов к API
+	// ВКонтакте по умолчанию.
+	DefaultConcurrency = 10
+	// DefaultRequestsPerSecond - количество запросов в секунду, выполняемых
+	// с одним access token, по умолчанию.
+	DefaultRequestsPerSecond = api.LimitUserToken
+)
+
+type NewOptions struct {
+	// Максимальное количество одновременно выполняемых запросов к API
+	// ВКонтакте для всех приложений. По умолчанию DefaultConcurrency.
+	Concurrency int
+	// Максимальное количество запросов в секунду, выполняемых с одним access
+	// token. По умолчанию DefaultRequestsPerSecond.
+	RequestsPerSecond int
+}
+
+// Ключ, по которому уведомления объединяются в пачки. В одну пачку могут
+// попасть только уведомления с одинаковыми сообщением и фрагментом.
+type batchKey struct {
+	message  string
+	fragment string
+}
+
+// Пачка уведомлений, отправляемая одним запросом.
+type batch struct {
+	batchKey
+	// Идентификаторы пользователей пачки.
+	userIds []user.Id
+}
+
+// Создает ссылку на описание ошибки отправки пачки.
+func (b *batch) toError(userIds []user.Id, code int, err error) *notification.BatchError {
+	return &notification.BatchError{
+		Message:   b.message,
+		Fragment:  b.fragment,
+		BatchSize: len(b.userIds),
+		UserIds:   userIds,
+		Code:      code,
+		Original:  err,
+	}
+}
+
+// Sender выполняет отправку уведомлений через API ВКонтакте.
+type Sender struct {
+	mu sync.RWMutex
+	// Экземпляры библиотеки для работы с API ВКонтакте. Для каждого
+	// приложения используется свой access token, так как отправлять
+	// уведомления можно только от лица приложения, которому он принадлежит.
+	clients map[appid.Id]*api.VK
+	// Максимальное количество запросов в секунду для одного access token.
+	requestsPerSecond int
+	// Семафор, ограничивающий количество одновременно выполняемых запросов.
+	sem chan struct{}
+}
+
+// SetAccessToken устанавливает access token, который будет использоваться
+// для отправки уведомлений от лица указанного приложения. Ранее
+// установленный токен заменяется.
+func (s *Sender) SetAccessToken(appId appid.Id, accessToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.clients[appId] = s.newClient(accessToken)
+}
+
+// RemoveAccessToken удаляет access token указанного приложения. После этого
+// отправка уведомлений от его лица становится невозможной.
+func (s *Sender) RemoveAccessToken(appId appid.Id) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.clients, appId)
+}
+
+func (s *Sender) CanSend(appId appid.Id) bool {
+	return s.getClient(appId) != nil
+}
+
+func (s *Sender) Send(
+	ctx context.Context,
+	appId appid.Id,
+	params []notification.Params,
+) (*notification.SendResult, *customerror.ServiceError) {
+	client := s.getClient(appId)
+	if client == nil {
+		return nil, customerror.NewServiceError(senders.ErrAppNotSupported)
+	}
+
+	result := &notification.SendResult{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	// Пробегаемся по каждой пачке и рассылаем уведомления в отдельных
+	// горутинах. Количество одновременно выполняемых запросов ограничено
+	// семафором, общим для всех приложений.
+	for _, b := range createBatches(params) {
+		select {
+		case s.sem <- struct{}{}:
+		case <-ctx.Done():
+			// Контекст отменён, оставшиеся пачки не отправляем.
+			mu.Lock()
+			result.InternalError = append(result.InternalError, b.userIds...)
+			result.Errors = append(result.Errors, *b.toError(b.userIds, 0, ctx.Err()))
+			mu.Unlock()
+			continue
+		}
+		wg.Add(1)
+
+		go func(b batch) {
+			defer func() {
+				<-s.sem
+				wg.Done()
+			}()
+
+			batchResult := &notification.SendResult{}
+			sendBatch(ctx, client, b, batchResult)
+
+			mu.Lock()
+			result.Merge(batchResult)
+			mu.Unlock()
+		}(b)
+	}
+	wg.Wait()
+
+	return result, nil
+}
+
+// Выполняет отправку уведомлений пачке пользователей и добавляет результаты
+// отправки в result. Каждый пользователь пачки попадает ровно в один раздел
+// результата.
+func sendBatch(
+	ctx context.Context,
+	client *api.VK,
+	b batch,
+	result *notification.SendResult,
+) {
+	reqParams := api.Params{
+		"user_ids": b.userIds,
+		"message":  b.message,
+	}
+	if b.fragment != "" {
+		reqParams["fragment"] = b.fragment
+	}
+	res, err := client.NotificationsSendMessage(reqParams.WithContext(ctx))
+
+	// Если произошла ошибка, вся пачка считается неотправленной ввиду
+	// внутренней ошибки.
+	if err != nil {
+		result.InternalError = append(result.InternalError, b.userIds...)
+		result.Errors = append(result.Errors, *b.toError(b.userIds, getErrorCode(err), err))
+		return
+	}
+
+	// Создаем карту результатов отправки по идентификаторам пользователей.
+	statuses := make(map[user.Id]int, len(res))
+	for i, r := range res {
+		statuses[user.Id(r.UserID)] = i
+	}
+
+	// Пользователи с неизвестными кодами ошибок, сгруппированные по кодам.
+	unknown := make(map[int][]user.Id)
+
+	// Пробегаемся по каждому пользователю пачки и добавляем его в свой раздел.
+	for _, uid := range b.userIds {
+		i, ok := statuses[uid]
+
+		// API не вернуло результат для этого пользователя.
+		if !ok {
+			result.UnknownError = append(result.UnknownError, uid)
+			unknown[0] = append(unknown[0], uid)
+			continue
+		}
+		r := res[i]
+
+		if r.Status {
+			result.Success = append(result.Success, uid)
+			continue
+		}
+
+		// Спецификация ошибок:
+		// https://dev.vk.com/method/notifications.sendMessage#Результат
+		switch r.Error.Code {
+		case 1, 4:
+			result.NotificationsDisabled = append(result.NotificationsDisabled, uid)
+		case 2:
+			result.HourRateLimitReached = append(result.HourRateLimitReached, uid)
+		case 3:
+			result.DayRateLimitReached = append(result.DayRateLimitReached, uid)
+		default:
+			result.UnknownError = append(result.UnknownError, uid)
+			unknown[r.Error.Code] = append(unknown[r.Error.Code], uid)
+		}
+	}
+
+	for code, userIds := range unknown {
+		result.Errors = append(result.Errors, *b.toError(userIds, code, ErrUnknownUserStatus))
+	}
+}
+
+// Возвращает код ошибки API ВКонтакте. Для ошибок, не связанных с API,
+// возвращает 0.
+func getErrorCode(err error) int {
+	var apiErr *api.Error
+
+	if errors.As(err, &apiErr) {
+		return int(apiErr.Code)
+	}
+	return 0
+}
+
+// Разбивает уведомления на пачки. В одну пачку попадают уведомления с
+// одинаковыми сообщением и фрагментом, но не более
+// SendNotificationUsersLimit пользователей.
+func createBatches(params []notification.Params) []batch {
+	var batches []batch
+
+	// Индекс последней пачки для каждой пары из сообщения и фрагмента.
+	lastBatches := make(map[batchKey]int)
+	// Пользователи, уже добавленные в пачки с такими сообщением и фрагментом.
+	added := make(map[batchKey]map[user.Id]bool)
+
+	for _, p := range params {
+		p.Message = truncateMessage(p.Message)
+		key := batchKey{message: p.Message, fragment: p.Fragment}
+
+		// Пользователю уже отправляется такое же уведомление.
+		if added[key][p.UserId] {
+			continue
+		}
+		if added[key] == nil {
+			added[key] = make(map[user.Id]bool)
+		}
+		added[key][p.UserId] = true
+
+		// Если подходящей пачки нет или она уже переполнена, добавляем новую.
+		i, ok := lastBatches[key]
+		if !ok || len(batches[i].userIds) == SendNotificationUsersLimit {
+			batches = append(batches, batch{batchKey: key})
+			i = len(batches) - 1
+			lastBatches[key] = i
+		}
+		batches[i].userIds = append(batches[i].userIds, p.UserId)
+	}
+	return batches
+}
+
+// Отрезает все символы сообщения после MessageMaxLength-ого, вставляя в
+// конце 3 точки. Это единственное адекватное решение, которые мы здесь
+// можем использовать. Сообщение обрезается по символам, а не байтам, чтобы
+// не получить некорректную строку UTF-8.
+func truncateMessage(message string) string {
+	if utf8.RuneCountInString(message) <= MessageMaxLength {
+		return message
+	}
+	runes := []rune(message)
+
+	return string(runes[:MessageMaxLength-3]) + "..."
+}
+
+// Создает экземпляр библиотеки для работы с API ВКонтакте с указанным access
+// token. Библиотека самостоятельно ограничивает количество запросов в
+// секунду для каждого экземпляра.
+func (s *Sender) newClient(accessToken string) *api.VK {
+	client := api.NewVK(accessToken)
+	client.Limit = s.requestsPerSecond
+
+	return client
+}
+
+// Возвращает экземпляр библиотеки для работы с API ВКонтакте, который
+// необходимо использовать для указанного приложения.
+func (s *Sender) getClient(appId appid.Id) *api.VK {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.clients[appId]
+}
+
+// New возвращает ссылку на новый экземпляр отправителя уведомлений через API
+// ВКонтакте. В качестве параметра принимает карту access token-ов
+// приложений, от лица которых будет выполняться отправка.
+func New(accessTokens map[appid.Id]string, options NewOptions) *Sender {
+	if options.Concurrency <= 0 {
+		options.Concurrency = DefaultConcurrency
+	}
+	if options.RequestsPerSecond <= 0 {
+		options.RequestsPerSecond = DefaultRequestsPerSecond
+	}
+	s := &Sender{
+		clients:           make(map[appid.Id]*api.VK, len(accessTokens)),
+		requestsPerSecond: options.RequestsPerSecond,
+		sem:               make(chan struct{}, options.Concurrency),
+	}
+
+	for appId, token := range accessTokens {
+		s.clients[appId] = s.newClient(token)
+	}
+	return s
+}
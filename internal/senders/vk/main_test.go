@@ -0,0 +1,350 @@
+package vk
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/SevereCloud/vksdk/v2/api"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Ответ API на отправку уведомления одному пользователю.
+type fakeStatus struct {
+	UserId int  `json:"user_id"`
+	Status bool `json:"status"`
+	Error  struct {
+		Code int `json:"code"`
+	} `json:"error"`
+}
+
+// Функция, формирующая ответ тестового API для пачки пользователей.
+// Возвращает тело поля response, либо код ошибки API.
+type fakeHandler func(userIds []int) (statuses []fakeStatus, apiErrorCode int)
+
+// Создает тестовый сервер, имитирующий метод notifications.sendMessage.
+func newFakeServer(t *testing.T, handler fakeHandler) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("некорректный запрос: %v", err)
+		}
+		var userIds []int
+		for _, s := range strings.Split(r.Form.Get("user_ids"), ",") {
+			id, err := strconv.Atoi(s)
+			if err != nil {
+				t.Errorf("некорректный идентификатор пользователя %q", s)
+			}
+			userIds = append(userIds, id)
+		}
+		statuses, code := handler(userIds)
+
+		w.Header().Set("Content-Type", "application/json")
+		if code != 0 {
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{"error_code": code, "error_msg": "error"},
+			})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"response": statuses})
+	}))
+}
+
+// Возвращает статус с ошибкой для пользователя.
+func failed(userId int, code int) fakeStatus {
+	s := fakeStatus{UserId: userId}
+	s.Error.Code = code
+
+	return s
+}
+
+// Возвращает карту разделов результата, в которые попал каждый пользователь.
+func bucketsOf(res *notification.SendResult) map[user.Id][]string {
+	buckets := make(map[user.Id][]string)
+	add := func(name string, ids []user.Id) {
+		for _, id := range ids {
+			buckets[id] = append(buckets[id], name)
+		}
+	}
+	add("success", res.Success)
+	add("disabled", res.NotificationsDisabled)
+	add("unknown", res.UnknownError)
+	add("hour", res.HourRateLimitReached)
+	add("day", res.DayRateLimitReached)
+	add("internal", res.InternalError)
+
+	return buckets
+}
+
+// Проверяет, что каждый пользователь попал ровно в ожидаемый раздел.
+func assertBuckets(t *testing.T, res *notification.SendResult, expected map[user.Id]string) {
+	t.Helper()
+
+	buckets := bucketsOf(res)
+	if len(buckets) != len(expected) {
+		t.Errorf("ожидалось %d пользователей в результате, получено %d", len(expected), len(buckets))
+	}
+	for id, bucket := range expected {
+		got := buckets[id]
+		if len(got) != 1 || got[0] != bucket {
+			t.Errorf("пользователь %d: ожидался раздел %q, получено %v", id, bucket, got)
+		}
+	}
+}
+
+// Возвращает отсортированную копию идентификаторов пользователей.
+func sorted(ids []user.Id) []user.Id {
+	res := append([]user.Id(nil), ids...)
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+
+	return res
+}
+
+func TestSendBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler fakeHandler
+		// Ожидаемые разделы пользователей.
+		expected map[user.Id]string
+		// Ожидаемые ошибки пачки, сгруппированные по коду.
+		errors map[int][]user.Id
+	}{
+		{
+			name: "все отправлены",
+			handler: func(ids []int) ([]fakeStatus, int) {
+				return []fakeStatus{{UserId: 1, Status: true}, {UserId: 2, Status: true}}, 0
+			},
+			expected: map[user.Id]string{1: "success", 2: "success"},
+		},
+		{
+			name: "известные коды ошибок",
+			handler: func(ids []int) ([]fakeStatus, int) {
+				return []fakeStatus{failed(1, 1), failed(2, 2), failed(3, 3), failed(4, 4)}, 0
+			},
+			expected: map[user.Id]string{1: "disabled", 2: "hour", 3: "day", 4: "disabled"},
+		},
+		{
+			name: "неизвестные коды ошибок",
+			handler: func(ids []int) ([]fakeStatus, int) {
+				return []fakeStatus{{UserId: 1, Status: true}, failed(2, 99), failed(3, 99), failed(4, 100)}, 0
+			},
+			expected: map[user.Id]string{1: "success", 2: "unknown", 3: "unknown", 4: "unknown"},
+			errors:   map[int][]user.Id{99: {2, 3}, 100: {4}},
+		},
+		{
+			name: "пользователи отсутствуют в ответе",
+			handler: func(ids []int) ([]fakeStatus, int) {
+				return []fakeStatus{{UserId: 1, Status: true}}, 0
+			},
+			expected: map[user.Id]string{1: "success", 2: "unknown", 3: "unknown"},
+			errors:   map[int][]user.Id{0: {2, 3}},
+		},
+		{
+			name: "ошибка API",
+			handler: func(ids []int) ([]fakeStatus, int) {
+				return nil, 15
+			},
+			expected: map[user.Id]string{1: "internal", 2: "internal"},
+			errors:   map[int][]user.Id{15: {1, 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newFakeServer(t, tt.handler)
+			defer srv.Close()
+
+			client := api.NewVK("token")
+			client.MethodURL = srv.URL + "/"
+			client.Limit = 0
+
+			var ids []user.Id
+			for id := range tt.expected {
+				ids = append(ids, id)
+			}
+			b := batch{batchKey: batchKey{message: "message", fragment: "fragment"}, userIds: sorted(ids)}
+			res := &notification.SendResult{}
+
+			sendBatch(context.Background(), client, b, res)
+
+			assertBuckets(t, res, tt.expected)
+
+			if len(res.Errors) != len(tt.errors) {
+				t.Fatalf("ожидалось %d ошибок пачки, получено %d", len(tt.errors), len(res.Errors))
+			}
+			for _, e := range res.Errors {
+				expectedIds, ok := tt.errors[e.Code]
+				if !ok {
+					t.Errorf("неожиданная ошибка пачки с кодом %d", e.Code)
+					continue
+				}
+				if e.Message != "message" || e.Fragment != "fragment" || e.BatchSize != len(ids) {
+					t.Errorf("некорректное описание пачки: %+v", e)
+				}
+				if got := sorted(e.UserIds); !equalIds(got, expectedIds) {
+					t.Errorf("код %d: ожидались пользователи %v, получено %v", e.Code, expectedIds, got)
+				}
+				if e.Original == nil {
+					t.Errorf("код %d: не указана исходная ошибка", e.Code)
+				}
+			}
+		})
+	}
+}
+
+func TestSendBatchTransportError(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+
+	client := api.NewVK("token")
+	client.MethodURL = url + "/"
+	client.Limit = 0
+
+	b := batch{batchKey: batchKey{message: "message"}, userIds: []user.Id{1, 2}}
+	res := &notification.SendResult{}
+
+	sendBatch(context.Background(), client, b, res)
+
+	assertBuckets(t, res, map[user.Id]string{1: "internal", 2: "internal"})
+	if len(res.Errors) != 1 || res.Errors[0].Code != 0 || len(res.Errors[0].UserIds) != 2 {
+		t.Errorf("ожидалась одна ошибка пачки с кодом 0, получено %+v", res.Errors)
+	}
+}
+
+func TestSend(t *testing.T) {
+	srv := newFakeServer(t, func(ids []int) ([]fakeStatus, int) {
+		statuses := make([]fakeStatus, 0, len(ids))
+
+		// Пользователи с идентификатором, кратным 10, отключили уведомления.
+		for _, id := range ids {
+			if id%10 == 0 {
+				statuses = append(statuses, failed(id, 1))
+				continue
+			}
+			statuses = append(statuses, fakeStatus{UserId: id, Status: true})
+		}
+		return statuses, 0
+	})
+	defer srv.Close()
+
+	s := New(map[appid.Id]string{1: "token"}, NewOptions{Concurrency: 2, RequestsPerSecond: 1000})
+	s.clients[1].MethodURL = srv.URL + "/"
+
+	expected := make(map[user.Id]string)
+	var params []notification.Params
+
+	for id := user.Id(1); id <= 250; id++ {
+		params = append(params, notification.Params{UserId: id, Message: "message"})
+		if id%10 == 0 {
+			expected[id] = "disabled"
+		} else {
+			expected[id] = "success"
+		}
+	}
+	// Дубликат уведомления не должен отправляться повторно.
+	params = append(params, notification.Params{UserId: 1, Message: "message"})
+
+	res, err := s.Send(context.Background(), 1, params)
+	if err != nil {
+		t.Fatalf("неожиданная ошибка: %v", err.Original)
+	}
+	assertBuckets(t, res, expected)
+}
+
+func TestSendCancelled(t *testing.T) {
+	srv := newFakeServer(t, func(ids []int) ([]fakeStatus, int) {
+		t.Error("запрос не должен выполняться после отмены контекста")
+		return nil, 0
+	})
+	defer srv.Close()
+
+	s := New(map[appid.Id]string{1: "token"}, NewOptions{RequestsPerSecond: 1000})
+	s.clients[1].MethodURL = srv.URL + "/"
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	params := []notification.Params{{UserId: 1, Message: "a"}, {UserId: 2, Message: "b"}}
+	res, err := s.Send(ctx, 1, params)
+	if err != nil {
+		t.Fatalf("неожиданная ошибка: %v", err.Original)
+	}
+	assertBuckets(t, res, map[user.Id]string{1: "internal", 2: "internal"})
+
+	for _, e := range res.Errors {
+		if !errors.Is(e.Original, context.Canceled) {
+			t.Errorf("ожидалась ошибка отмены контекста, получено %v", e.Original)
+		}
+	}
+}
+
+func TestTruncateMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		expected string
+	}{
+		{
+			name:     "короткое сообщение",
+			message:  "Привет",
+			expected: "Привет",
+		},
+		{
+			name:     "сообщение максимальной длины",
+			message:  strings.Repeat("я", MessageMaxLength),
+			expected: strings.Repeat("я", MessageMaxLength),
+		},
+		{
+			name:     "длинное сообщение на латинице",
+			message:  strings.Repeat("a", MessageMaxLength+1),
+			expected: strings.Repeat("a", MessageMaxLength-3) + "...",
+		},
+		{
+			name:     "длинное сообщение на кириллице",
+			message:  strings.Repeat("я", MessageMaxLength+1),
+			expected: strings.Repeat("я", MessageMaxLength-3) + "...",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateMessage(tt.message)
+
+			if got != tt.expected {
+				t.Errorf("ожидалось %q, получено %q", tt.expected, got)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("некорректная строка UTF-8: %q", got)
+			}
+		})
+	}
+}
+
+func TestSendAppNotSupported(t *testing.T) {
+	s := New(nil, NewOptions{})
+
+	if _, err := s.Send(context.Background(), 1, nil); err == nil {
+		t.Error("ожидалась ошибка для приложения без access token")
+	}
+}
+
+// Сравнивает списки идентификаторов пользователей.
+func equalIds(a []user.Id, b []user.Id) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+// AppPolicy описывает ограничения отправки уведомлений приложения, общие
+// для всех его задач. Нулевые значения означают отсутствие ограничения.
+type AppPolicy struct {
+	// Максимальное количество уведомлений приложения, отправляемых одному
+	// пользователю за последние сутки.
+	MaxPerDay uint
+}
+
+// Описывает расход ограничений приложения пользователями в рамках
+// обработки одной порции пользователей.
+type appBudget struct {
+	appId  appid.Id
+	policy AppPolicy
+	// Момент, относительно которого учитываются отправки.
+	now time.Time
+	// Количество уведомлений приложения, отправленных пользователям в
+	// текущей итерации.
+	sent map[user.Id]uint
+}
+
+// Возвращает true в случае, если пользователю ещё можно отправить
+// уведомление приложения. Учитываются отправки всех задач приложения из
+// истории пользователя, а также отправки текущей итерации.
+func (b *appBudget) allows(u *user.User) bool {
+	if b.policy.MaxPerDay == 0 {
+		return true
+	}
+	count := b.sent[u.Id]
+	since := b.now.Add(-24 * time.Hour)
+
+	for _, t := range u.GetApp(b.appId).Tasks {
+		count += uint(t.SentSince(since))
+	}
+	return count < b.policy.MaxPerDay
+}
+
+// Учитывает отправку уведомления указанным пользователям.
+func (b *appBudget) add(userIds []user.Id) {
+	for _, uid := range userIds {
+		b.sent[uid]++
+	}
+}
+
+// Возвращает ссылку на новый экземпляр appBudget для указанного приложения.
+func (s *Service) newAppBudget(appId appid.Id, now time.Time) *appBudget {
+	return &appBudget{
+		appId:  appId,
+		policy: s.appPolicies[appId],
+		now:    now,
+		sent:   make(map[user.Id]uint),
+	}
+}
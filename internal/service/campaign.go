@@ -0,0 +1,76 @@
+package service
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/campaign"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+// AddCampaign добавляет разовую кампанию. Кампания выполняется как обычная
+// задача и автоматически удаляется после завершения промежутка отправки во
+// всех часовых поясах. Ход выполнения кампании обновляется по мере отправки
+// уведомлений, в том числе повторной.
+func (s *Service) AddCampaign(c *campaign.Campaign) error {
+	s.campaignsMu.Lock()
+	defer s.campaignsMu.Unlock()
+
+	if err := s.AddTask(c.Task); err != nil {
+		return err
+	}
+	s.campaigns[taskKey{appId: c.Task.AppId, taskId: c.Task.Id}] = c
+
+	return nil
+}
+
+// Удаляет кампании, промежуток отправки которых завершился во всех
+// часовых поясах.
+func (s *Service) completeCampaigns(now time.Time) {
+	s.campaignsMu.Lock()
+	defer s.campaignsMu.Unlock()
+
+	for key, c := range s.campaigns {
+		if !c.IsCompleted(now) {
+			continue
+		}
+		c.Complete()
+		_ = s.tasks.remove(key.appId, key.taskId)
+		delete(s.campaigns, key)
+	}
+}
+
+// Учитывает пользователей, переданных в задачу, в ходе выполнения кампании
+// с указанной задачей, если такая есть.
+func (s *Service) addCampaignMatched(key taskKey, users []user.User) {
+	s.campaignsMu.Lock()
+	defer s.campaignsMu.Unlock()
+
+	c, ok := s.campaigns[key]
+	if !ok {
+		return
+	}
+	userIds := make([]user.Id, len(users))
+	for i, u := range users {
+		userIds[i] = u.Id
+	}
+	c.AddMatched(userIds)
+}
+
+// Учитывает результат отправки уведомлений в ходе выполнения кампании с
+// указанной задачей, если такая есть.
+func (s *Service) addCampaignSendResult(key taskKey, res *notification.SendResult) {
+	s.campaignsMu.Lock()
+	defer s.campaignsMu.Unlock()
+
+	if c, ok := s.campaigns[key]; ok {
+		c.AddSendResult(res)
+	}
+}
+
+// Удаляет кампанию с указанной задачей, если такая есть.
+func (s *Service) forgetCampaign(key taskKey) {
+	s.campaignsMu.Lock()
+	defer s.campaignsMu.Unlock()
+
+	delete(s.campaigns, key)
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/campaign"
+	"github.com/wolframdeus/noitifications-service/internal/clock"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/senders"
+	"github.com/wolframdeus/noitifications-service/internal/senders/memory"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"testing"
+	"time"
+)
+
+// Проверяет, что в ходе выполнения кампании каждый пользователь
+// учитывается один раз, сколько бы итераций его ни выбирали.
+func TestCampaignProgressAcrossTicks(t *testing.T) {
+	tests := []struct {
+		name     string
+		process  task.ProcessFunc
+		sender   senders.Sender
+		expected campaign.Progress
+	}{
+		{
+			name: "задача не отправляет уведомления",
+			process: func([]user.User) ([]notification.Params, *customerror.TaskError) {
+				return nil, nil
+			},
+			sender:   memory.New(),
+			expected: campaign.Progress{Matched: 2},
+		},
+		{
+			name:     "уведомления отправлены",
+			process:  sendToAll("campaign"),
+			sender:   memory.New(),
+			expected: campaign.Progress{Matched: 2, Sent: 2},
+		},
+		{
+			name:     "уведомления не отправлены",
+			process:  sendToAll("campaign"),
+			sender:   &failingSender{attempts: make(map[user.Id]int)},
+			expected: campaign.Progress{Matched: 2, Failed: 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(10)
+			p.addUsers(0, 1, 2)
+			c := clock.NewFake(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+			s := newTestService(t, p, tt.sender, NewOptions{Clock: c})
+
+			cmp := campaign.New(1, testAppId, task.NewDate(2026, time.January, 1), internal.Window{
+				From: internal.Time{Hours: 10},
+				To:   internal.Time{Hours: 12},
+			}, tt.process)
+			if err := s.AddCampaign(cmp); err != nil {
+				t.Fatalf("не удалось добавить кампанию: %v", err)
+			}
+
+			for i := 0; i < 3; i++ {
+				runTick(s)
+				c.Add(time.Minute)
+			}
+
+			if got := cmp.Progress(); got != tt.expected {
+				t.Errorf("ожидалось %+v, получено %+v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,11 +1,19 @@
 package service
 
 import (
+	goerrors "errors"
 	"github.com/getsentry/sentry-go"
 	"github.com/wolframdeus/noitifications-service/internal/errors"
 	"strconv"
 )
 
+var (
+	ErrTickSkipped            = goerrors.New("тик пропущен, так как предыдущая итерация ещё не завершилась")
+	ErrRetryAttemptsExhausted = goerrors.New("исчерпаны попытки повторной отправки уведомления")
+	ErrTaskAlreadyExists      = goerrors.New("задача уже добавлена")
+	ErrTaskNotFound           = goerrors.New("задача не найдена")
+)
+
 type CaptureOptions struct {
 	// Список тегов.
 	Tags map[string]string
@@ -0,0 +1,150 @@
+package service
+
+import (
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+// Возвращает список пользователей, которым уведомление задачи ещё не
+// отправлялось в текущем промежутке отправки. Начало промежутка вычисляется
+// исходя из часового пояса каждого пользователя.
+func (s *Service) filterDelivered(t *task.Task, users []user.User, now time.Time) []user.User {
+	res := make([]user.User, 0, len(users))
+
+	for _, u := range users {
+		lastSentAt := u.GetTask(t.AppId, t.Id).LastSentAt
+
+		if !lastSentAt.IsZero() && !lastSentAt.Before(t.GetWindowStart(u.TimezoneAt(now), now)) {
+			continue
+		}
+		res = append(res, u)
+	}
+	return res
+}
+
+// Возвращает список пользователей, отправка уведомления задачи которым не
+// нарушает ограничений частоты отправки, а также количество исключенных
+// пользователей.
+func (s *Service) filterCapped(t *task.Task, users []user.User, now time.Time) ([]user.User, int) {
+	res := make([]user.User, 0, len(users))
+
+	for _, u := range users {
+		if !t.Caps.Allows(u.GetTask(t.AppId, t.Id), now) {
+			continue
+		}
+		res = append(res, u)
+	}
+	return res, len(users) - len(res)
+}
+
+// Возвращает список пользователей, которым ещё можно отправить уведомление
+// с учетом ограничений приложения, а также количество исключенных
+// пользователей.
+func (s *Service) filterBudget(b *appBudget, users []user.User) ([]user.User, int) {
+	res := make([]user.User, 0, len(users))
+
+	for _, u := range users {
+		if !b.allows(&u) {
+			continue
+		}
+		res = append(res, u)
+	}
+	return res, len(users) - len(res)
+}
+
+// Возвращает список пользователей, которые не были переданы в другую
+// задачу группы задачи и которым в текущем промежутке отправки задачи не
+// отправлялось уведомление другой задачи группы, а также количество
+// исключенных пользователей. Оставшиеся пользователи закрепляются за
+// задачей. Задачи должны передаваться в порядке убывания приоритета, tasks -
+// все задачи приложения.
+func (s *Service) filterExclusive(
+	t *task.Task,
+	tasks []task.Task,
+	claimed map[string]map[user.Id]bool,
+	users []user.User,
+	now time.Time,
+) ([]user.User, int) {
+	if t.Group == "" {
+		return users, 0
+	}
+	if claimed[t.Group] == nil {
+		claimed[t.Group] = make(map[user.Id]bool)
+	}
+	res := make([]user.User, 0, len(users))
+
+	for _, u := range users {
+		if claimed[t.Group][u.Id] || isDeliveredByGroup(t, tasks, &u, now) {
+			continue
+		}
+		claimed[t.Group][u.Id] = true
+		res = append(res, u)
+	}
+	return res, len(users) - len(res)
+}
+
+// Возвращает true в случае, если пользователю можно повторно отправить
+// уведомление задачи: пользователь разрешил отправку уведомлений, для него
+// нет действующего ограничения на отправку, а отправка не нарушает
+// ограничений задачи и приложения.
+func (s *Service) allowsRetry(t *task.Task, b *appBudget, u *user.User, now time.Time) bool {
+	return u.IsNotificationsEnabled(t.AppId) &&
+		!u.IsInCooldown(t.AppId, now) &&
+		t.Caps.Allows(u.GetTask(t.AppId, t.Id), now) &&
+		b.allows(u)
+}
+
+// Возвращает true в случае, если в текущем промежутке отправки задачи
+// пользователю уже было отправлено уведомление другой задачи той же группы.
+func isDeliveredByGroup(t *task.Task, tasks []task.Task, u *user.User, now time.Time) bool {
+	start := t.GetWindowStart(u.TimezoneAt(now), now)
+
+	for i := range tasks {
+		other := &tasks[i]
+		if other.Group != t.Group || other.Id == t.Id {
+			continue
+		}
+		lastSentAt := u.GetTask(other.AppId, other.Id).LastSentAt
+
+		if !lastSentAt.IsZero() && !lastSentAt.Before(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// Возвращает список уведомлений задачи с корректными параметрами. Об
+// уведомлениях с некорректными параметрами сообщается как об ошибке задачи.
+func (s *Service) filterInvalidParams(
+	t *task.Task,
+	params []notification.Params,
+) []notification.Params {
+	res := make([]notification.Params, 0, len(params))
+	var invalid []notification.Params
+	var firstErr error
+
+	for _, p := range params {
+		if err := p.Validate(); err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			invalid = append(invalid, p)
+			continue
+		}
+		res = append(res, p)
+	}
+
+	if firstErr != nil {
+		s.captureTaskError(customerror.NewTaskError(t.AppId, t.Id, firstErr), &CaptureOptions{
+			Contexts: map[string]interface{}{
+				"Parameters": map[string]interface{}{
+					"invalidParams": invalid,
+				},
+			},
+		})
+	}
+	return res
+}
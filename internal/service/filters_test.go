@@ -0,0 +1,63 @@
+package service
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/clock"
+	"github.com/wolframdeus/noitifications-service/internal/senders/memory"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Проверяет, что в течение нескольких итераций пользователь получает
+// уведомление только одной задачи группы.
+func TestExclusiveGroupAcrossTicks(t *testing.T) {
+	tests := []struct {
+		name string
+		// Промежуток отправки задачи с большим приоритетом.
+		highTo internal.Time
+		// Время второй итерации после первой.
+		delay time.Duration
+	}{
+		{
+			name:   "промежутки совпадают",
+			highTo: internal.Time{Hours: 12},
+			delay:  time.Minute,
+		},
+		{
+			name:   "промежуток задачи с большим приоритетом завершился",
+			highTo: internal.Time{Hours: 11},
+			delay:  90 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			high := newSendingTask(1, internal.Time{Hours: 10}, tt.highTo)
+			high.Group = "group"
+			high.Priority = 10
+
+			low := newSendingTask(2, internal.Time{Hours: 10}, internal.Time{Hours: 12})
+			low.Group = "group"
+
+			p := newFakeProvider(10)
+			p.addUsers(0, 1)
+			c := clock.NewFake(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+			sender := memory.New()
+			s := newTestService(t, p, sender, NewOptions{Clock: c}, low, high)
+
+			runTick(s)
+			c.Add(tt.delay)
+			runTick(s)
+
+			sent := sender.Sent(testAppId)
+			if len(sent) != 1 || sent[0].Message != "task 1" {
+				t.Errorf("ожидалось одно уведомление задачи 1, получено %+v", sent)
+			}
+			if got := sentCounts(sender); !reflect.DeepEqual(got, map[user.Id]int{1: 1}) {
+				t.Errorf("ожидалось одно уведомление пользователю 1, получено %v", got)
+			}
+		})
+	}
+}
@@ -1,9 +1,13 @@
 package service
 
 import (
+	"context"
 	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/providers"
 	"github.com/wolframdeus/noitifications-service/internal/task"
-	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/timezone"
 	"github.com/wolframdeus/noitifications-service/internal/user"
 	"sort"
@@ -12,28 +16,267 @@ import (
 )
 
 const (
-	// SendNotificationUsersLimit - максимальное количество пользователей,
-	// которым за раз можно отправить уведомление.
-	SendNotificationUsersLimit = 100
+	// Максимальное время одной операции сохранения состояния итерации и
+	// результатов отправки.
+	persistTimeout = 10 * time.Second
+	// Время, в течение которого после прерывания итерации продолжается
+	// сохранение состояния итерации и уже полученных результатов отправки.
+	abortPersistTimeout = 3 * time.Second
+	// Максимальное опоздание, с которым продолжается выборка пользователей
+	// прерванной итерации.
+	resumeMaxAge = 15 * time.Minute
 )
 
 type tasksTimezoneMap map[*task.Task][]timezone.Range
 
+// Описывает выполняемую итерацию сервиса.
+type iteration struct {
+	mu sync.Mutex
+	// Функция для отмены контекста итерации.
+	cancel context.CancelFunc
+	// Контекст, от которого создаются контексты сохранения состояния
+	// итерации и результатов отправки. В отличие от контекста итерации,
+	// отменяется только спустя abortPersistTimeout после её прерывания.
+	persistCtx context.Context
+	// Функция для отмены persistCtx.
+	cancelPersist context.CancelFunc
+	// Канал, который закрывается по завершении итерации.
+	done chan struct{}
+	// Результат, описывающий необработанную часть итерации.
+	result StopResult
+	// Запись об итерации, которая сохраняется в провайдере.
+	checkpoint *checkpoint.Checkpoint
+}
+
+// Увеличивает количество пользователей, переданных в задачу.
+func (it *iteration) addMatched(t *task.Task, count int) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.checkpoint.GetTask(t.AppId, t.Id).Matched += count
+}
+
+// Увеличивает количество пользователей, исключенных ввиду ограничений
+// частоты отправки задачи.
+func (it *iteration) addCapped(t *task.Task, count int) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.checkpoint.GetTask(t.AppId, t.Id).Capped += count
+}
+
+// Увеличивает количество пользователей, исключенных ввиду исчерпания
+// ограничений приложения.
+func (it *iteration) addOverBudget(t *task.Task, count int) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.checkpoint.GetTask(t.AppId, t.Id).OverBudget += count
+}
+
+// Увеличивает количество пользователей, переданных в задачу группы с
+// большим приоритетом.
+func (it *iteration) addExcluded(t *task.Task, count int) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.checkpoint.GetTask(t.AppId, t.Id).Excluded += count
+}
+
+// Увеличивает счетчики отправленных и неотправленных уведомлений задачи.
+func (it *iteration) addSendResult(t *task.Task, res *notification.SendResult) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	counters := it.checkpoint.GetTask(t.AppId, t.Id)
+	counters.Sent += len(res.Success)
+	counters.Failed += len(res.NotificationsDisabled) +
+		len(res.UnknownError) +
+		len(res.HourRateLimitReached) +
+		len(res.DayRateLimitReached) +
+		len(res.InternalError)
+}
+
+// Обновляет курсор и состояние записи об итерации и возвращает её копию.
+func (it *iteration) updateCheckpoint(
+	cursor user.Id,
+	status checkpoint.Status,
+	now time.Time,
+) *checkpoint.Checkpoint {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.checkpoint.Cursor = cursor
+	it.checkpoint.Status = status
+	it.checkpoint.UpdatedAt = now
+
+	return it.checkpoint.Copy()
+}
+
+// Увеличивает счетчики необработанных данных итерации.
+func (it *iteration) addUnprocessed(users, unsent, unsaved int) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.result.UnprocessedUsers += users
+	it.result.UnsentNotifications += unsent
+	it.result.UnsavedResults += unsaved
+}
+
+// Отмечает итерацию как прерванную на указанном курсоре.
+func (it *iteration) interrupt(cursor user.Id) {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	it.result.Interrupted = true
+	it.result.Cursor = cursor
+}
+
+// Возвращает копию результата итерации.
+func (it *iteration) getResult() *StopResult {
+	it.mu.Lock()
+	defer it.mu.Unlock()
+
+	res := it.result
+	return &res
+}
+
+// Возвращает контекст для сохранения состояния итерации и результатов
+// отправки, который не отменяется вместе с контекстом итерации.
+func (it *iteration) newPersistContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(it.persistCtx, persistTimeout)
+}
+
+// Прерывает итерацию. Отправка уведомлений прекращается немедленно, а
+// сохранение состояния итерации и уже полученных результатов отправки -
+// спустя abortPersistTimeout.
+func (it *iteration) abort() {
+	it.cancel()
+	time.AfterFunc(abortPersistTimeout, it.cancelPersist)
+}
+
+// Создает ссылку на новый экземпляр iteration.
+func newIteration(cancel context.CancelFunc) *iteration {
+	persistCtx, cancelPersist := context.WithCancel(context.Background())
+
+	return &iteration{
+		cancel:        cancel,
+		persistCtx:    persistCtx,
+		cancelPersist: cancelPersist,
+		done:          make(chan struct{}),
+	}
+}
+
 // Вызывает итерацию работы сервиса, которая подразумевает получение списка
-// пользователей для всех задач, а также передачу их в задачи.
-func (s *Service) runIteration() {
+// пользователей для всех задач, а также передачу их в задачи. В случае, если
+// предыдущая итерация не была завершена, сначала завершается её выборка
+// пользователей, после чего начинается новая. В случае закрытия канала stop
+// итерация завершает обработку текущей порции пользователей и не
+// запрашивает следующую. Отмена контекста прерывает обработку немедленно.
+func (s *Service) runIteration(ctx context.Context, stop <-chan struct{}, it *iteration) {
+	// Запоминаем время начала итерации, относительно которого будут
+	// вычисляться промежутки отправки задач.
+	now := s.clock.Now()
+
+	// Итерация работает со снимком реестра задач, поэтому изменения задач
+	// вступают в силу только со следующей итерации.
+	tasks := s.tasks.snapshot()
+
+	// Перед выборкой новых пользователей повторяем отправку уведомлений,
+	// которые ранее не удалось отправить ввиду временной ошибки.
+	s.drainRetryQueue(ctx, it, tasks, now)
+
+	// Завершаем выборку прерванной итерации. Пользователи выбираются с
+	// сохраненного курсора в тех же интервалах часовых поясов, а промежутки
+	// отправки задач вычисляются на момент начала прерванной итерации.
+	// Поэтому пользователи, промежуток отправки которых завершился, пока
+	// итерация была прервана, всё равно получат уведомление.
+	if cp := s.getResumeCheckpoint(ctx, now); cp != nil {
+		_, tasksTzMap := getTimezonesMeta(tasks, cp.StartedAt, s.tickInterval)
+
+		if !s.scan(ctx, stop, it, tasks, tasksTzMap, cp) {
+			return
+		}
+	}
+
 	// Получаем текущий список всех часовых задач.
-	tzRanges, tasksTzMap := s.getTimezonesMeta()
+	tzRanges, tasksTzMap := getTimezonesMeta(tasks, now, s.tickInterval)
+
+	s.scan(ctx, stop, it, tasks, tasksTzMap, checkpoint.New(now, tzRanges))
+}
+
+// Возвращает запись о незавершенной итерации, выборку пользователей
+// которой необходимо завершить, либо nil. Итерация могла быть прервана в
+// том числе до перезапуска сервиса. Итерации, начатые более чем за
+// resumeMaxAge до предыдущего тика, не продолжаются: уведомления их
+// пользователям были бы отправлены со слишком большим опозданием.
+func (s *Service) getResumeCheckpoint(ctx context.Context, now time.Time) *checkpoint.Checkpoint {
+	since := now.Add(-s.tickInterval - resumeMaxAge)
+	cp := s.resume
+	s.resume = nil
+
+	if cp == nil {
+		cp, _ = s.safeGetUnfinishedCheckpoint(ctx, since)
+	}
+	if cp == nil || cp.StartedAt.Before(since) {
+		return nil
+	}
+	return cp
+}
+
+// Выполняет выборку пользователей, описанную записью об итерации, начиная
+// с её курсора, и передает их в задачи. Промежутки отправки задач
+// вычисляются на момент начала выборки. Возвращает true в случае, если
+// выборка была завершена.
+func (s *Service) scan(
+	ctx context.Context,
+	stop <-chan struct{},
+	it *iteration,
+	tasks []task.Task,
+	tasksTzMap tasksTimezoneMap,
+	cp *checkpoint.Checkpoint,
+) bool {
+	now := cp.StartedAt
+	tzRanges := cp.Ranges
+
+	// Получаем идентификаторы приложений и их задач для того, чтобы
+	// распараллелить их дальнейшую обработку.
+	appTasksMap := getAppTasksMap(tasks)
+	appIds := getAppIds(appTasksMap)
+
+	it.mu.Lock()
+	it.checkpoint = cp
+	it.mu.Unlock()
+
+	var cursor = cp.Cursor
+	s.saveCheckpoint(ctx, it, cursor, checkpoint.StatusRunning)
 
-	var cursor = user.Id(0)
 	for {
+		// Сервис останавливается, новые порции пользователей не запрашиваем.
+		select {
+		case <-stop:
+			s.interruptIteration(it, cursor)
+			return false
+		default:
+		}
+		if ctx.Err() != nil {
+			s.interruptIteration(it, cursor)
+			return false
+		}
+
 		// Порционно получаем список пользователей, удовлетворяющих условию по
-		// часовым поясам.
-		getResult, err := s.safeGetUsersByTimezones(tzRanges, cursor)
-		if err != nil {
-			// TODO: Здесь необходимо ещё несколько раз попробовать получить
-			//  данные. Может быть соединение с провайдером моргнуло.
+		// часовым поясам. Временные ошибки провайдера повторяем согласно
+		// политике повторного выполнения.
+		var getResult *providers.GetUsersByTimezonesResult
+		err := s.withRetry(ctx, func() (err *customerror.ServiceError) {
+			getResult, err = s.safeGetUsersByTimezones(ctx, appIds, tzRanges, cursor, now)
 			return
+		})
+		if err != nil {
+			// Получить пользователей так и не удалось. Следующая итерация
+			// продолжит обработку с текущего курсора.
+			s.interruptIteration(it, cursor)
+			return false
 		}
 
 		// Пользователей нет, работать нам не с кем. Осуществляем ранний выход.
@@ -41,14 +284,18 @@ func (s *Service) runIteration() {
 			break
 		}
 
-		// Сортируем пользователей по возрастанию их часового пояса.
+		// Сортируем пользователей по возрастанию их текущего часового пояса.
 		sort.SliceStable(getResult.Users, func(i, j int) bool {
-			return getResult.Users[i].Timezone < getResult.Users[j].Timezone
+			return getResult.Users[i].TimezoneAt(now) < getResult.Users[j].TimezoneAt(now)
 		})
 
 		// Сортируем пользователей по задачам исходя из того, в каких часовых
 		// поясах задача выполняется, а также исходя часового пояса пользователя.
-		taskUsersMap := make(map[taskid.Id][]user.User, len(s.tasks))
+		taskUsersMap := make(map[taskKey][]user.User, len(tasks))
+
+		// Получаем уведомления, ожидающие повторной отправки пользователям
+		// порции. Такие уведомления повторно не отправляются.
+		pending := s.getPendingRetries(ctx, getResult.Users)
 
 		for t, timezones := range tasksTzMap {
 			// Нам необходимо понять относительно которого часового пояса нужно
@@ -58,43 +305,101 @@ func (s *Service) runIteration() {
 			// Пробегаемся по всем пользователям и проверяем, попадает ли их часовой
 			// пояс под часовые пояса задачи.
 			for _, u := range getResult.Users {
+				userTz := u.TimezoneAt(now)
+
 				// Если получилось так, что часовой пояс пользователя больше
 				// максимального часового пояса задачи, делаем ранний выход.
-				if comparedTimezone < u.Timezone {
+				if comparedTimezone < userTz {
 					break
 				}
+				// Пользователь запретил отправку уведомлений от лица приложения
+				// задачи.
+				if !u.IsNotificationsEnabled(t.AppId) {
+					continue
+				}
+				// Для пользователя действует ограничение на отправку уведомлений
+				// приложения. Уведомление будет отправлено после его окончания,
+				// если промежуток отправки задачи ещё не завершится.
+				if u.IsInCooldown(t.AppId, now) {
+					continue
+				}
+				// Уведомление задачи ожидает повторной отправки пользователю.
+				if pending[deliveryKey{appId: t.AppId, taskId: t.Id, userId: u.Id}] {
+					continue
+				}
 				for _, tz := range timezones {
-					if tz.ContainsTimezone(u.Timezone) {
-						taskUsersMap[t.Id] = append(taskUsersMap[t.Id], u)
+					if tz.ContainsTimezone(userTz) {
+						key := taskKey{appId: t.AppId, taskId: t.Id}
+						taskUsersMap[key] = append(taskUsersMap[key], u)
 					}
 				}
 			}
 		}
 
-		// Получаем идентификаторы приложений и их задач для того, чтобы
-		// распараллелить их дальнейшую обработку.
-		appTasksMap := s.getAppTasksMap()
 		var wg sync.WaitGroup
 
 		// Пробегаемся по каждому приложению и для него выделяем отдельную
 		// горутину, в которой будет выполняться обработка всех его задач.
-		for _, tasks := range appTasksMap {
+		for appId, tasks := range appTasksMap {
 			wg.Add(1)
 
-			go func(tasks []task.Task) {
+			go func(appId appid.Id, tasks []task.Task) {
 				defer wg.Done()
 
+				// Ограничения приложения расходуются задачами в порядке их
+				// приоритета.
+				budget := s.newAppBudget(appId, now)
+
+				// Пользователи, закрепленные за задачами групп взаимоисключающих
+				// задач приложения.
+				claimed := make(map[string]map[user.Id]bool)
+
 				// Пробегаемся по каждой задаче и передаем в неё список подходящих
 				// пользователей.
 				for _, t := range tasks {
+					// Контекст отменён, оставшиеся задачи не обрабатываем.
+					if ctx.Err() != nil {
+						it.addUnprocessed(len(taskUsersMap[taskKey{appId: t.AppId, taskId: t.Id}]), 0, 0)
+						continue
+					}
+
 					// Если эта задача не зарегистрирована в карте с задачами и
 					// пользователями, то подходящих для этой задачи пользователей просто
 					// нет. Мы можем перейти к следующей задаче.
-					users, ok := taskUsersMap[t.Id]
+					users, ok := taskUsersMap[taskKey{appId: t.AppId, taskId: t.Id}]
 					if !ok {
 						continue
 					}
 
+					// Исключаем пользователей, которые уже переданы в задачу той же
+					// группы с большим приоритетом, либо получили уведомление другой
+					// задачи группы. Пользователи закрепляются за задачей до
+					// применения остальных фильтров, чтобы задача с меньшим
+					// приоритетом не получила пользователя, которому задача с
+					// большим приоритетом уже отправила уведомление.
+					users, excluded := s.filterExclusive(&t, tasks, claimed, users, now)
+					if excluded > 0 {
+						it.addExcluded(&t, excluded)
+					}
+
+					// Исключаем пользователей, которым уведомление этой задачи уже
+					// было отправлено в текущем промежутке отправки.
+					users = s.filterDelivered(&t, users, now)
+
+					// Исключаем пользователей, отправка которым нарушит ограничения
+					// частоты отправки задачи.
+					users, capped := s.filterCapped(&t, users, now)
+					if capped > 0 {
+						it.addCapped(&t, capped)
+					}
+
+					// Исключаем пользователей, для которых исчерпаны ограничения
+					// приложения.
+					users, overBudget := s.filterBudget(budget, users)
+					if overBudget > 0 {
+						it.addOverBudget(&t, overBudget)
+					}
+
 					// Если пользователей в задаче нет, переходим ко следующей.
 					if len(users) == 0 {
 						continue
@@ -102,6 +407,8 @@ func (s *Service) runIteration() {
 
 					// Передаём в задачу пользователей для проверки на отправку
 					// уведомления.
+					it.addMatched(&t, len(users))
+					s.addCampaignMatched(taskKey{appId: t.AppId, taskId: t.Id}, users)
 					params, processErr := s.safeProcess(&t, users)
 					if processErr != nil {
 						continue
@@ -113,47 +420,133 @@ func (s *Service) runIteration() {
 						continue
 					}
 
+					// Исключаем уведомления с некорректными параметрами.
+					params = s.filterInvalidParams(&t, params)
+					if len(params) == 0 {
+						continue
+					}
+
+					// Контекст был отменён, пока задача обрабатывала пользователей.
+					if ctx.Err() != nil {
+						it.addUnprocessed(0, len(params), 0)
+						continue
+					}
+
 					// Отправляем уведомления пользователям.
-					sendResult, err := s.sendNotifications(params)
+					sendResult, err := s.sendNotifications(ctx, &t, params)
 					if err != nil {
 						continue
 					}
+					it.addSendResult(&t, sendResult)
+					s.addCampaignSendResult(taskKey{appId: t.AppId, taskId: t.Id}, sendResult)
+					budget.add(sendResult.Success)
+
+					// Уведомления уже отправлены, поэтому результаты сохраняем
+					// даже в случае отмены контекста итерации.
+					persistCtx, cancelPersist := it.newPersistContext()
+
+					// Уведомления, которые не удалось отправить ввиду временной
+					// ошибки, добавляем в очередь повторной отправки.
+					s.enqueueRetries(persistCtx, &t, users, params, sendResult, now)
 
 					// Сохраняем факт отправки уведомления.
-					err = s.safeSaveSendResult(sendResult, t.AppId, t.Id, time.Now())
+					err = s.safeSaveSendResult(persistCtx, sendResult, params, t.AppId, t.Id, s.clock.Now(), t.GetHistoryLimit())
+					cancelPersist()
 					if err != nil {
+						it.addUnprocessed(0, 0, len(params))
 						continue
 					}
 				}
-			}(tasks)
+			}(appId, tasks)
 		}
 
 		// Ожидаем выполнения всех горутин.
 		wg.Wait()
 
+		// Контекст был отменён во время обработки порции. Текущая порция
+		// считается необработанной.
+		if ctx.Err() != nil {
+			s.interruptIteration(it, cursor)
+			return false
+		}
 		if !getResult.HasMore {
 			break
 		}
-		// Перезаписываем курсор для следующего запроса.
+		// Перезаписываем курсор для следующего запроса и сохраняем его, чтобы
+		// в случае перезапуска сервиса продолжить обработку с этого места.
 		cursor = getResult.Cursor
+		s.saveCheckpoint(ctx, it, cursor, checkpoint.StatusRunning)
+	}
+	s.saveCheckpoint(ctx, it, cursor, checkpoint.StatusCompleted)
+	s.completeCampaigns(s.clock.Now())
+
+	return true
+}
+
+// Сохраняет запись об итерации с указанными курсором и состоянием.
+func (s *Service) saveCheckpoint(
+	ctx context.Context,
+	it *iteration,
+	cursor user.Id,
+	status checkpoint.Status,
+) {
+	cp := it.updateCheckpoint(cursor, status, s.clock.Now())
+
+	// Идентификатор записи устанавливается провайдером при первом сохранении.
+	if err := s.safeSaveCheckpoint(ctx, cp); err == nil {
+		it.mu.Lock()
+		it.checkpoint.Id = cp.Id
+		it.mu.Unlock()
 	}
 }
 
-// Возвращает список интервалов часовых поясов, в которых должны находиться
-// пользователи, чтобы попасть хотя бы в одну задачу.
-func (s *Service) getTimezonesMeta() ([]timezone.Range, tasksTimezoneMap) {
-	if len(s.tasks) == 0 {
+// Прерывает итерацию на указанном курсоре. Следующая итерация продолжит
+// обработку пользователей начиная с этого курсора. Контекст итерации к
+// этому моменту может быть уже отменён, поэтому запись об итерации
+// сохраняется с отдельным контекстом.
+func (s *Service) interruptIteration(it *iteration, cursor user.Id) {
+	ctx, cancel := it.newPersistContext()
+	defer cancel()
+
+	it.interrupt(cursor)
+	s.saveCheckpoint(ctx, it, cursor, checkpoint.StatusInterrupted)
+
+	it.mu.Lock()
+	s.resume = it.checkpoint.Copy()
+	it.mu.Unlock()
+}
+
+// Возвращает список интервалов часовых поясов, в которых в момент now должны
+// находиться пользователи, чтобы попасть хотя бы в одну задачу. Промежутки
+// задач короче интервала между итерациями расширяются до него, чтобы ни
+// один часовой пояс не был пропущен между итерациями.
+func getTimezonesMeta(
+	tasks []task.Task,
+	now time.Time,
+	tickInterval time.Duration,
+) ([]timezone.Range, tasksTimezoneMap) {
+	if len(tasks) == 0 {
 		return []timezone.Range{}, tasksTimezoneMap{}
 	}
 	// Для начала создаем список интервалов часов поясов, пользователей в
 	// которых нам необходимо получить.
-	ranges := make([]timezone.Range, 0, len(s.tasks))
-	tasksTzMap := make(tasksTimezoneMap, len(s.tasks))
+	ranges := make([]timezone.Range, 0, len(tasks))
+	tasksTzMap := make(tasksTimezoneMap, len(tasks))
 
-	for _, t := range s.tasks {
+	for _, t := range tasks {
 		tTemp := t
-		tasksTzMap[&tTemp] = t.GetTimezones()
-		ranges = append(ranges, tasksTzMap[&tTemp]...)
+		tz := t.GetTimezones(now, tickInterval)
+
+		// Задача сейчас не выполняется ни в одном часовом поясе, например,
+		// ввиду её расписания.
+		if len(tz) == 0 {
+			continue
+		}
+		tasksTzMap[&tTemp] = tz
+		ranges = append(ranges, tz...)
+	}
+	if len(ranges) == 0 {
+		return []timezone.Range{}, tasksTzMap
 	}
 
 	// Сортируем массив интервалов по возрастанию их начала.
@@ -181,12 +574,32 @@ func (s *Service) getTimezonesMeta() ([]timezone.Range, tasksTimezoneMap) {
 }
 
 // Возвращает карту с ключом в виде идентификатора приложения и значением в
-// виде списка задач, которые этому приложению принадлежат.
-func (s *Service) getAppTasksMap() map[appid.Id][]task.Task {
+// виде списка задач, которые этому приложению принадлежат. Задачи каждого
+// приложения отсортированы по убыванию приоритета.
+func getAppTasksMap(tasks []task.Task) map[appid.Id][]task.Task {
 	res := make(map[appid.Id][]task.Task)
 
-	for _, t := range s.tasks {
+	for _, t := range tasks {
 		res[t.AppId] = append(res[t.AppId], t)
 	}
+	for _, tasks := range res {
+		sort.SliceStable(tasks, func(i, j int) bool {
+			return tasks[i].Priority > tasks[j].Priority
+		})
+	}
+	return res
+}
+
+// Возвращает отсортированный список идентификаторов приложений из карты
+// задач приложений.
+func getAppIds(appTasksMap map[appid.Id][]task.Task) []appid.Id {
+	res := make([]appid.Id, 0, len(appTasksMap))
+
+	for id := range appTasksMap {
+		res = append(res, id)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i] < res[j]
+	})
 	return res
 }
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
+	"github.com/wolframdeus/noitifications-service/internal/clock"
+	"github.com/wolframdeus/noitifications-service/internal/senders/memory"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Возвращает задачу с промежутком отправки между указанными моментами
+// локального времени.
+func newTestTask(id taskid.Id, from internal.Time, to internal.Time) task.Task {
+	return *task.NewTask(id, 1, internal.Window{From: from, To: to}, nil)
+}
+
+// Возвращает тестовый момент времени по Гринвичу. 1 января 2026 года -
+// четверг.
+func utc(hours int, minutes int, seconds int) time.Time {
+	return time.Date(2026, 1, 1, hours, minutes, seconds, 0, time.UTC)
+}
+
+func TestGetTimezonesMeta(t *testing.T) {
+	// Задача, которая по расписанию не выполняется 1 января.
+	skipped := newTestTask(4, internal.Time{Hours: 22}, internal.Time{Hours: 2})
+	skipped.Schedule = task.Schedule{Weekdays: task.Thursday}
+
+	tests := []struct {
+		name         string
+		tasks        []task.Task
+		now          time.Time
+		tickInterval time.Duration
+		expected     []timezone.Range
+		// Ожидаемые интервалы каждой задачи.
+		expectedTasks map[taskid.Id][]timezone.Range
+	}{
+		{
+			name:          "без задач",
+			now:           utc(0, 30, 0),
+			tickInterval:  time.Minute,
+			expected:      []timezone.Range{},
+			expectedTasks: map[taskid.Id][]timezone.Range{},
+		},
+		{
+			name: "пересекающиеся промежутки склеиваются",
+			tasks: []task.Task{
+				newTestTask(1, internal.Time{Hours: 22}, internal.Time{Hours: 2}),
+				newTestTask(2, internal.Time{Hours: 1}, internal.Time{Hours: 3}),
+			},
+			now:          utc(0, 30, 0),
+			tickInterval: time.Minute,
+			expected:     []timezone.Range{{From: -150, To: 150}},
+			expectedTasks: map[taskid.Id][]timezone.Range{
+				1: {{From: -150, To: 90}},
+				2: {{From: 30, To: 150}},
+			},
+		},
+		{
+			name: "ночной промежуток в соседних сутках",
+			tasks: []task.Task{
+				newTestTask(1, internal.Time{Hours: 22}, internal.Time{Hours: 2}),
+				newTestTask(2, internal.Time{Hours: 8}, internal.Time{Hours: 9}),
+			},
+			now:          utc(12, 30, 0),
+			tickInterval: time.Minute,
+			expected:     []timezone.Range{{From: -720, To: -630}, {From: -270, To: -210}, {From: 570, To: 810}},
+			expectedTasks: map[taskid.Id][]timezone.Range{
+				1: {{From: -720, To: -630}, {From: 570, To: 810}},
+				2: {{From: -270, To: -210}},
+			},
+		},
+		{
+			name: "отсечение по MinTimezone и MaxTimezone",
+			tasks: []task.Task{
+				newTestTask(1, internal.Time{Hours: 8}, internal.Time{Hours: 20}),
+			},
+			now:          utc(0, 0, 0),
+			tickInterval: time.Minute,
+			expected:     []timezone.Range{{From: timezone.MinTimezone, To: -240}, {From: 480, To: timezone.MaxTimezone}},
+			expectedTasks: map[taskid.Id][]timezone.Range{
+				1: {{From: timezone.MinTimezone, To: -240}, {From: 480, To: timezone.MaxTimezone}},
+			},
+		},
+		{
+			name: "короткий промежуток расширяется до интервала итераций",
+			tasks: []task.Task{
+				newTestTask(1, internal.Time{Hours: 10, Seconds: 10}, internal.Time{Hours: 10, Seconds: 40}),
+			},
+			now:          utc(9, 0, 0),
+			tickInterval: 5 * time.Minute,
+			expected:     []timezone.Range{{From: 61, To: 65}},
+			expectedTasks: map[taskid.Id][]timezone.Range{
+				1: {{From: 61, To: 65}},
+			},
+		},
+		{
+			name: "задача вне расписания не учитывается",
+			tasks: []task.Task{
+				newTestTask(1, internal.Time{Hours: 1}, internal.Time{Hours: 3}),
+				skipped,
+			},
+			now:          utc(0, 30, 0),
+			tickInterval: time.Minute,
+			expected:     []timezone.Range{{From: 30, To: 150}},
+			expectedTasks: map[taskid.Id][]timezone.Range{
+				1: {{From: 30, To: 150}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ranges, tasksTzMap := getTimezonesMeta(tt.tasks, tt.now, tt.tickInterval)
+
+			if !reflect.DeepEqual(ranges, tt.expected) {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, ranges)
+			}
+			if len(tasksTzMap) != len(tt.expectedTasks) {
+				t.Errorf("ожидалось %d задач, получено %d", len(tt.expectedTasks), len(tasksTzMap))
+			}
+			for tsk, tz := range tasksTzMap {
+				if expected, ok := tt.expectedTasks[tsk.Id]; !ok || !reflect.DeepEqual(tz, expected) {
+					t.Errorf("задача %d: ожидалось %v, получено %v", tsk.Id, expected, tz)
+				}
+			}
+		})
+	}
+}
+
+// Проверяет, что при выполнении итераций с интервалом tickInterval в
+// течение суток пользователи каждого часового пояса хотя бы раз попадают в
+// промежуток отправки задачи.
+func TestGetTimezonesMetaTicks(t *testing.T) {
+	tests := []struct {
+		name         string
+		task         task.Task
+		tickInterval time.Duration
+	}{
+		{
+			name:         "дневной промежуток",
+			task:         newTestTask(1, internal.Time{Hours: 10}, internal.Time{Hours: 11}),
+			tickInterval: time.Minute,
+		},
+		{
+			name:         "ночной промежуток",
+			task:         newTestTask(1, internal.Time{Hours: 22}, internal.Time{Hours: 2}),
+			tickInterval: 5 * time.Minute,
+		},
+		{
+			name:         "промежуток короче интервала",
+			task:         newTestTask(1, internal.Time{Hours: 10, Seconds: 10}, internal.Time{Hours: 10, Seconds: 40}),
+			tickInterval: time.Minute,
+		},
+		{
+			name:         "ночной промежуток короче интервала",
+			task:         newTestTask(1, internal.Time{Hours: 23, Minutes: 59, Seconds: 50}, internal.Time{Seconds: 20}),
+			tickInterval: 5 * time.Minute,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := clock.NewFake(utc(0, 0, 7))
+			covered := make(map[timezone.Timezone]bool)
+
+			for elapsed := time.Duration(0); elapsed < 24*time.Hour; elapsed += tt.tickInterval {
+				ranges, _ := getTimezonesMeta([]task.Task{tt.task}, c.Now(), tt.tickInterval)
+
+				for _, r := range ranges {
+					for tz := r.From; tz <= r.To; tz++ {
+						covered[tz] = true
+					}
+				}
+				c.Add(tt.tickInterval)
+			}
+
+			for tz := timezone.Timezone(timezone.MinTimezone); tz <= timezone.MaxTimezone; tz++ {
+				if !covered[tz] {
+					t.Fatalf("часовой пояс %d пропущен", tz)
+				}
+			}
+		})
+	}
+}
+
+// Проверяет, что выборка пользователей прерванной итерации завершается на
+// следующем тике, даже если промежуток отправки к этому моменту
+// завершился.
+func TestRunIterationResume(t *testing.T) {
+	tests := []struct {
+		name string
+		// Время между прерванной и следующей итерацией.
+		delay time.Duration
+		// Ожидаемое количество уведомлений каждому пользователю.
+		expected map[user.Id]int
+	}{
+		{
+			name:     "выборка продолжается",
+			delay:    time.Minute,
+			expected: map[user.Id]int{1: 1, 2: 1, 3: 1},
+		},
+		{
+			name:     "слишком большое опоздание",
+			delay:    resumeMaxAge + 2*time.Minute,
+			expected: map[user.Id]int{1: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(1)
+			p.addUsers(0, 1, 2, 3)
+			c := clock.NewFake(utc(22, 59, 30))
+			sender := memory.New()
+			s := newTestService(t, p, sender, NewOptions{Clock: c}, newSendingTask(1, internal.Time{Hours: 22}, internal.Time{Hours: 23}))
+
+			// Вторая порция пользователей не может быть получена, итерация
+			// прерывается после первого пользователя.
+			p.failGetUsersFrom = 2
+			runTick(s)
+
+			if cp := p.lastCheckpoint(); cp.Status != checkpoint.StatusInterrupted || cp.Cursor != 1 {
+				t.Fatalf("ожидалась итерация, прерванная на курсоре 1, получено %+v", cp)
+			}
+
+			// К следующему тику промежуток отправки завершился.
+			p.failGetUsersFrom = 0
+			c.Add(tt.delay)
+			runTick(s)
+
+			if got := sentCounts(sender); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, got)
+			}
+			if cp := p.lastCheckpoint(); cp.Status != checkpoint.StatusCompleted {
+				t.Errorf("ожидалась завершенная итерация, получено %+v", cp)
+			}
+		})
+	}
+}
@@ -1,14 +1,22 @@
 package service
 
 import (
+	"context"
 	"errors"
-	"github.com/SevereCloud/vksdk/v2/api"
 	"github.com/getsentry/sentry-go"
 	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/campaign"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
+	"github.com/wolframdeus/noitifications-service/internal/clock"
 	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
 	"github.com/wolframdeus/noitifications-service/internal/providers"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
+	"github.com/wolframdeus/noitifications-service/internal/senders"
 	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/user"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +27,19 @@ type SetAllowStatusForUser func(appId appid.Id, userId user.Id, allowed bool) er
 type NewOptions struct {
 	// Интервал между итерациями сервиса, которые вызывают отправку уведомлений.
 	TickInterval time.Duration
+	// Необходимо ли запустить первую итерацию сразу после запуска сервиса,
+	// не дожидаясь первого тика.
+	RunOnStart bool
+	// Политика повторного выполнения операций провайдера, завершившихся
+	// временной ошибкой. По умолчанию используется retry.NewDefaultPolicy.
+	RetryPolicy *retry.Policy
+	// Политика повторной отправки уведомлений, отправка которых завершилась
+	// временной ошибкой. Попытки выполняются не чаще, чем наступают тики.
+	SendRetryPolicy *retry.Policy
+	// Ограничения отправки уведомлений приложений, общие для всех их задач.
+	AppPolicies map[appid.Id]AppPolicy
+	// Источник текущего времени. По умолчанию clock.Real.
+	Clock clock.Clock
 	// Список опций, которые далее передаются для инициализации Sentry Hub.
 	SentryOptions *sentry.ClientOptions
 }
@@ -27,47 +48,143 @@ type Service struct {
 	provider providers.Provider
 	// Интервал между итерациями сервиса, которые вызывают отправку уведомлений.
 	tickInterval time.Duration
-	// Список задач, выполняемых сервисом.
-	tasks []task.Task
-	// Экземпляр библиотеки для работы с API ВКонтакте.
-	vk *api.VK
+	// Необходимо ли запустить первую итерацию сразу после запуска сервиса.
+	runOnStart bool
+	// Реестр задач, выполняемых сервисом.
+	tasks *taskRegistry
+	// Способ доставки уведомлений пользователям.
+	sender senders.Sender
 	// Hub Sentry для логирования ошибок.
 	sentryHub *sentry.Hub
+	// Мьютекс, защищающий запуск и остановку сервиса.
+	mu sync.Mutex
 	// Тикер, который вызывает итерации сервиса.
 	ticker *time.Ticker
+	// Канал, закрытие которого останавливает планировщик итераций.
+	stop chan struct{}
+	// Выполняемая в данный момент итерация.
+	iteration *iteration
+	// Количество тиков, пропущенных ввиду того, что предыдущая итерация ещё
+	// не завершилась.
+	skippedTicks uint64
+	// Политика повторного выполнения операций провайдера.
+	retryPolicy *retry.Policy
+	// Политика повторной отправки уведомлений.
+	sendRetryPolicy *retry.Policy
+	// Ограничения отправки уведомлений приложений.
+	appPolicies map[appid.Id]AppPolicy
+	// Источник текущего времени.
+	clock clock.Clock
+	// Мьютекс, защищающий список кампаний.
+	campaignsMu sync.Mutex
+	// Выполняемые разовые кампании.
+	campaigns map[taskKey]*campaign.Campaign
+	// Запись о прерванной итерации, которую необходимо продолжить в
+	// следующей итерации. Используется только внутри итераций, которые не
+	// могут выполняться одновременно.
+	resume *checkpoint.Checkpoint
 }
 
-// AddTask добавляет новую задачу.
-func (s *Service) AddTask(tasks ...task.Task) {
-	s.tasks = append(s.tasks, tasks...)
+// AddTask добавляет новые задачи. Возвращает ошибку в случае, если
+// способ доставки не поддерживает отправку уведомлений от лица приложения
+// какой-либо из задач, задача некорректна, либо задача с таким же
+// приложением и идентификатором уже добавлена. В этом случае ни одна из задач не
+// добавляется. Задачи начинают выполняться со следующей итерации.
+func (s *Service) AddTask(tasks ...task.Task) error {
+	for _, t := range tasks {
+		if !s.sender.CanSend(t.AppId) {
+			return newTaskRegistryError(t.AppId, t.Id, senders.ErrAppNotSupported)
+		}
+	}
+	return s.tasks.add(tasks...)
+}
+
+// RemoveTask удаляет задачу. Выполняемая итерация завершает обработку
+// задачи, следующие итерации её не выполняют.
+func (s *Service) RemoveTask(appId appid.Id, taskId taskid.Id) error {
+	if err := s.tasks.remove(appId, taskId); err != nil {
+		return err
+	}
+	s.forgetCampaign(taskKey{appId: appId, taskId: taskId})
+
+	return nil
+}
+
+// ReplaceTask заменяет ранее добавленную задачу с тем же приложением и
+// идентификатором. Возвращает ошибку в случае, если новая версия задачи
+// некорректна. Новая версия задачи выполняется со следующей итерации.
+func (s *Service) ReplaceTask(t task.Task) error {
+	if !s.sender.CanSend(t.AppId) {
+		return newTaskRegistryError(t.AppId, t.Id, senders.ErrAppNotSupported)
+	}
+	return s.tasks.replace(t)
+}
+
+// PauseTask приостанавливает выполнение задачи начиная со следующей
+// итерации.
+func (s *Service) PauseTask(appId appid.Id, taskId taskid.Id) error {
+	return s.tasks.setPaused(appId, taskId, true)
+}
+
+// ResumeTask возобновляет выполнение приостановленной задачи начиная со
+// следующей итерации.
+func (s *Service) ResumeTask(appId appid.Id, taskId taskid.Id) error {
+	return s.tasks.setPaused(appId, taskId, false)
 }
 
-// Start выполняет запуск сервиса.
-// TODO: Этот код стоит исправить, т.к. он допускает параллельный вызов
-//  нескольких итераций или их пересечение.
+// Start выполняет запуск сервиса. Итерации запускаются каждые TickInterval,
+// при этом одновременно может выполняться не более одной итерации.
 func (s *Service) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	if s.ticker != nil {
 		return
 	}
 	s.ticker = time.NewTicker(s.tickInterval)
+	s.stop = make(chan struct{})
 
-	go func() {
-		select {
-		case <-s.ticker.C:
-			s.runIteration()
-		}
-	}()
+	go s.schedule(s.ticker, s.stop)
 }
 
-// Stop выполняет остановку сервиса.
-// TODO: Эта функция должна поддерживать graceful shutdown и по этой причине,
-//  возможно, она должна принимать context.
-func (s *Service) Stop() {
+// Stop выполняет остановку сервиса. Новые итерации перестают запускаться, а
+// выполняемая итерация завершает отправку уведомлений текущей порции
+// пользователей и сохранение результатов. В случае, если контекст будет
+// отменён раньше, выполняемая итерация прерывается: отправка уведомлений
+// прекращается немедленно, а состояние итерации и уже полученные
+// результаты отправки сохраняются не дольше abortPersistTimeout. Таким
+// образом, Stop завершается не позже, чем через abortPersistTimeout после
+// отмены контекста. Возвращает описание необработанной части итерации.
+func (s *Service) Stop(ctx context.Context) *StopResult {
+	s.mu.Lock()
 	if s.ticker == nil {
-		return
+		s.mu.Unlock()
+		return &StopResult{}
 	}
 	s.ticker.Stop()
+	close(s.stop)
 	s.ticker = nil
+	s.stop = nil
+	it := s.iteration
+	s.mu.Unlock()
+
+	if it == nil {
+		return &StopResult{}
+	}
+
+	select {
+	case <-it.done:
+	case <-ctx.Done():
+		it.abort()
+		<-it.done
+	}
+	return it.getResult()
+}
+
+// SkippedTicks возвращает количество тиков, пропущенных ввиду того, что
+// предыдущая итерация не успела завершиться за TickInterval.
+func (s *Service) SkippedTicks() uint64 {
+	return atomic.LoadUint64(&s.skippedTicks)
 }
 
 // SetAllowStatusForUser изменяет разрешение на отправку уведомлений
@@ -75,12 +192,13 @@ func (s *Service) Stop() {
 // TODO: Возможно, стоит предоставить возможность выполнять upsert в случае,
 //  если пользователь не существует.
 func (s *Service) SetAllowStatusForUser(
+	ctx context.Context,
 	userId user.Id,
 	appId appid.Id,
 	allowed bool,
 	user *user.User,
 ) *customerror.ServiceError {
-	return s.safeSetAllowStatusForUser(userId, appId, allowed, user)
+	return s.safeSetAllowStatusForUser(ctx, userId, appId, allowed, user)
 }
 
 func (s *Service) Cleanup() {
@@ -90,12 +208,27 @@ func (s *Service) Cleanup() {
 // New создаёт ссылку на новый экземпляр Service.
 func New(
 	provider providers.Provider,
-	accessToken string,
+	sender senders.Sender,
 	options NewOptions,
 ) (*Service, error) {
 	if options.TickInterval == 0 {
 		return nil, errors.New(`"TickInterval" не был указан`)
 	}
+	if options.Clock == nil {
+		options.Clock = clock.Real{}
+	}
+	if options.RetryPolicy == nil {
+		options.RetryPolicy = retry.NewDefaultPolicy()
+	}
+	if options.SendRetryPolicy == nil {
+		options.SendRetryPolicy = &retry.Policy{
+			MaxAttempts:  5,
+			InitialDelay: time.Minute,
+			MaxDelay:     30 * time.Minute,
+			Multiplier:   2,
+			Jitter:       0.2,
+		}
+	}
 	sentryHub := sentry.CurrentHub().Clone()
 
 	// Если указаны опции инициализации Sentry-клиента, используем их.
@@ -107,8 +240,16 @@ func New(
 		sentryHub.BindClient(client)
 	}
 	return &Service{
-		provider:  provider,
-		vk:        api.NewVK(accessToken),
-		sentryHub: sentryHub,
+		provider:        provider,
+		tickInterval:    options.TickInterval,
+		runOnStart:      options.RunOnStart,
+		retryPolicy:     options.RetryPolicy,
+		sendRetryPolicy: options.SendRetryPolicy,
+		appPolicies:     options.AppPolicies,
+		clock:           options.Clock,
+		sender:          sender,
+		sentryHub:       sentryHub,
+		tasks:           &taskRegistry{},
+		campaigns:       make(map[taskKey]*campaign.Campaign),
 	}, nil
 }
@@ -0,0 +1,440 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/providers"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
+	"github.com/wolframdeus/noitifications-service/internal/senders"
+	"github.com/wolframdeus/noitifications-service/internal/senders/memory"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAppId = 1
+
+var errFakeProvider = errors.New("ошибка провайдера")
+
+// Провайдер, хранящий данные в памяти. Реализует только то поведение,
+// которое необходимо сервису.
+type fakeProvider struct {
+	mu sync.Mutex
+	// Максимальное количество пользователей, возвращаемое
+	// GetUsersByTimezones.
+	limit int
+	users map[user.Id]*user.User
+	// Сохраненные записи об итерациях в порядке их создания.
+	checkpoints []*checkpoint.Checkpoint
+	retries     map[string]retry.Item
+	lastId      int
+	// Номер вызова GetUsersByTimezones, начиная с которого он завершается
+	// ошибкой. Нулевое значение означает отсутствие ошибок.
+	failGetUsersFrom int
+	getUsersCalls    int
+}
+
+func (p *fakeProvider) GetUsersByTimezones(
+	_ context.Context,
+	appIds []appid.Id,
+	tz []timezone.Range,
+	cursor user.Id,
+	now time.Time,
+) (*providers.GetUsersByTimezonesResult, *customerror.ServiceError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.getUsersCalls++
+	if p.failGetUsersFrom > 0 && p.getUsersCalls >= p.failGetUsersFrom {
+		return nil, customerror.NewServiceError(errFakeProvider)
+	}
+
+	var res []user.User
+	for _, id := range p.sortedIds() {
+		u := p.users[id]
+		if id <= cursor || !inRanges(tz, u.TimezoneAt(now)) || !isAnyAppAllowed(u, appIds, now) {
+			continue
+		}
+		if len(res) == p.limit {
+			return providers.NewGetUsersByTimezonesResult(res[len(res)-1].Id, res, true), nil
+		}
+		res = append(res, copyUser(u))
+	}
+	if len(res) == 0 {
+		return providers.NewGetUsersByTimezonesResult(0, nil, false), nil
+	}
+	return providers.NewGetUsersByTimezonesResult(res[len(res)-1].Id, res, false), nil
+}
+
+func (p *fakeProvider) GetUsers(_ context.Context, userIds []user.Id) ([]user.User, *customerror.ServiceError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []user.User
+	for _, id := range userIds {
+		if u, ok := p.users[id]; ok {
+			res = append(res, copyUser(u))
+		}
+	}
+	return res, nil
+}
+
+func (p *fakeProvider) SetAllowStatusForUser(
+	_ context.Context,
+	userId user.Id,
+	appId appid.Id,
+	allowed bool,
+	_ *user.User,
+) *customerror.ServiceError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.updateApp(userId, appId, func(app *user.App) {
+		app.AreNotificationsEnabled = allowed
+	})
+	return nil
+}
+
+func (p *fakeProvider) SaveSendResult(
+	_ context.Context,
+	results *notification.SendResult,
+	params []notification.Params,
+	appId appid.Id,
+	taskId taskid.Id,
+	date time.Time,
+	_ uint,
+) *customerror.ServiceError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	fragments := make(map[user.Id]string, len(params))
+	for _, prm := range params {
+		fragments[prm.UserId] = prm.Fragment
+	}
+	for _, uid := range results.Success {
+		p.updateApp(uid, appId, func(app *user.App) {
+			t := app.Tasks[taskId]
+			t.SendCount++
+			t.LastSentAt = date
+			t.History = append([]user.HistoryItem{{SentAt: date, Fragment: fragments[uid]}}, t.History...)
+			app.Tasks[taskId] = t
+		})
+	}
+	for _, uid := range results.NotificationsDisabled {
+		p.updateApp(uid, appId, func(app *user.App) {
+			app.AreNotificationsEnabled = false
+		})
+	}
+	return nil
+}
+
+func (p *fakeProvider) SaveCheckpoint(_ context.Context, c *checkpoint.Checkpoint) *customerror.ServiceError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c.Id == "" {
+		c.Id = p.nextId()
+		p.checkpoints = append(p.checkpoints, c.Copy())
+		return nil
+	}
+	for i, saved := range p.checkpoints {
+		if saved.Id == c.Id {
+			p.checkpoints[i] = c.Copy()
+		}
+	}
+	return nil
+}
+
+func (p *fakeProvider) GetUnfinishedCheckpoint(
+	_ context.Context,
+	since time.Time,
+) (*checkpoint.Checkpoint, *customerror.ServiceError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i := len(p.checkpoints) - 1; i >= 0; i-- {
+		c := p.checkpoints[i]
+		if c.Status != checkpoint.StatusCompleted && !c.UpdatedAt.Before(since) {
+			return c.Copy(), nil
+		}
+	}
+	return nil, nil
+}
+
+func (p *fakeProvider) SaveRetryItems(_ context.Context, items []retry.Item) *customerror.ServiceError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, item := range items {
+		if item.Id == "" {
+			item.Id = p.nextId()
+		}
+		p.retries[item.Id] = item
+	}
+	return nil
+}
+
+func (p *fakeProvider) GetDueRetryItems(
+	_ context.Context,
+	now time.Time,
+	limit int64,
+) ([]retry.Item, *customerror.ServiceError) {
+	return p.findRetryItems(func(item *retry.Item) bool {
+		return !item.NextAttemptAt.After(now)
+	}, limit), nil
+}
+
+func (p *fakeProvider) GetRetryItemsByUsers(
+	_ context.Context,
+	userIds []user.Id,
+) ([]retry.Item, *customerror.ServiceError) {
+	ids := make(map[user.Id]bool, len(userIds))
+	for _, id := range userIds {
+		ids[id] = true
+	}
+	return p.findRetryItems(func(item *retry.Item) bool {
+		return ids[item.UserId]
+	}, 0), nil
+}
+
+func (p *fakeProvider) DeleteRetryItems(_ context.Context, ids []string) *customerror.ServiceError {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range ids {
+		delete(p.retries, id)
+	}
+	return nil
+}
+
+// Возвращает записи об уведомлениях для повторной отправки,
+// удовлетворяющие условию. Нулевое значение limit означает отсутствие
+// ограничения.
+func (p *fakeProvider) findRetryItems(match func(item *retry.Item) bool, limit int64) []retry.Item {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var res []retry.Item
+	for _, item := range p.retries {
+		if match(&item) {
+			res = append(res, item)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].NextAttemptAt.Before(res[j].NextAttemptAt)
+	})
+	if limit > 0 && int64(len(res)) > limit {
+		res = res[:limit]
+	}
+	return res
+}
+
+// Изменяет состояние приложения пользователя. Вызывается под мьютексом.
+func (p *fakeProvider) updateApp(userId user.Id, appId appid.Id, update func(app *user.App)) {
+	u, ok := p.users[userId]
+	if !ok {
+		return
+	}
+	app := u.Apps[appId]
+	if app.Tasks == nil {
+		app.Tasks = make(map[taskid.Id]user.Task)
+	}
+	update(&app)
+	u.Apps[appId] = app
+}
+
+// Возвращает отсортированный список идентификаторов пользователей.
+// Вызывается под мьютексом.
+func (p *fakeProvider) sortedIds() []user.Id {
+	ids := make([]user.Id, 0, len(p.users))
+	for id := range p.users {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	return ids
+}
+
+// Возвращает новый идентификатор записи. Вызывается под мьютексом.
+func (p *fakeProvider) nextId() string {
+	p.lastId++
+	return strconv.Itoa(p.lastId)
+}
+
+// Добавляет пользователей с указанным часовым поясом, разрешивших отправку
+// уведомлений тестового приложения.
+func (p *fakeProvider) addUsers(tz timezone.Timezone, ids ...user.Id) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, id := range ids {
+		u := user.New(id, tz)
+		u.Apps = map[appid.Id]user.App{testAppId: {AreNotificationsEnabled: true}}
+		p.users[id] = u
+	}
+}
+
+// Возвращает последнюю сохраненную запись об итерации.
+func (p *fakeProvider) lastCheckpoint() *checkpoint.Checkpoint {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.checkpoints) == 0 {
+		return nil
+	}
+	return p.checkpoints[len(p.checkpoints)-1].Copy()
+}
+
+// Возвращает ссылку на новый экземпляр fakeProvider, возвращающий не более
+// limit пользователей за раз.
+func newFakeProvider(limit int) *fakeProvider {
+	return &fakeProvider{
+		limit:   limit,
+		users:   make(map[user.Id]*user.User),
+		retries: make(map[string]retry.Item),
+	}
+}
+
+// Возвращает true в случае, если часовой пояс входит хотя бы в один из
+// интервалов.
+func inRanges(ranges []timezone.Range, tz timezone.Timezone) bool {
+	for _, r := range ranges {
+		if r.ContainsTimezone(tz) {
+			return true
+		}
+	}
+	return false
+}
+
+// Возвращает true в случае, если пользователь разрешил отправку уведомлений
+// хотя бы одному из приложений и для него нет действующего ограничения.
+func isAnyAppAllowed(u *user.User, appIds []appid.Id, now time.Time) bool {
+	for _, id := range appIds {
+		if u.IsNotificationsEnabled(id) && !u.IsInCooldown(id, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Возвращает глубокую копию пользователя.
+func copyUser(u *user.User) user.User {
+	res := *u
+	res.Apps = make(map[appid.Id]user.App, len(u.Apps))
+
+	for appId, app := range u.Apps {
+		tasks := make(map[taskid.Id]user.Task, len(app.Tasks))
+		for taskId, t := range app.Tasks {
+			t.History = append([]user.HistoryItem(nil), t.History...)
+			tasks[taskId] = t
+		}
+		app.Tasks = tasks
+		res.Apps[appId] = app
+	}
+	return res
+}
+
+// Возвращает функцию обработки, отправляющую уведомление с указанным
+// сообщением всем переданным пользователям.
+func sendToAll(message string) task.ProcessFunc {
+	return func(users []user.User) ([]notification.Params, *customerror.TaskError) {
+		params := make([]notification.Params, len(users))
+		for i, u := range users {
+			params[i] = notification.Params{UserId: u.Id, Message: message}
+		}
+		return params, nil
+	}
+}
+
+// Возвращает задачу тестового приложения, отправляющую уведомление всем
+// пользователям в промежуток с from до to локального времени.
+func newSendingTask(id taskid.Id, from internal.Time, to internal.Time) task.Task {
+	return *task.NewTask(id, testAppId, internal.Window{From: from, To: to}, sendToAll("task "+strconv.Itoa(int(id))))
+}
+
+// Способ доставки, отправка уведомлений которым всегда завершается
+// временной ошибкой.
+type failingSender struct {
+	mu sync.Mutex
+	// Количество попыток отправки каждому пользователю.
+	attempts map[user.Id]int
+}
+
+func (s *failingSender) CanSend(appid.Id) bool {
+	return true
+}
+
+func (s *failingSender) Send(
+	_ context.Context,
+	_ appid.Id,
+	params []notification.Params,
+) (*notification.SendResult, *customerror.ServiceError) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	result := &notification.SendResult{}
+
+	for _, p := range params {
+		s.attempts[p.UserId]++
+		result.InternalError = append(result.InternalError, p.UserId)
+	}
+	return result, nil
+}
+
+// Создает сервис с тестовыми провайдером и способом доставки. Интервал
+// между итерациями по умолчанию равен минуте, а операции провайдера не
+// повторяются.
+func newTestService(
+	t *testing.T,
+	p *fakeProvider,
+	sender senders.Sender,
+	opts NewOptions,
+	tasks ...task.Task,
+) *Service {
+	t.Helper()
+
+	if opts.TickInterval == 0 {
+		opts.TickInterval = time.Minute
+	}
+	if opts.RetryPolicy == nil {
+		opts.RetryPolicy = &retry.Policy{MaxAttempts: 1}
+	}
+	s, err := New(p, sender, opts)
+	if err != nil {
+		t.Fatalf("не удалось создать сервис: %v", err)
+	}
+	if err := s.AddTask(tasks...); err != nil {
+		t.Fatalf("не удалось добавить задачи: %v", err)
+	}
+	return s
+}
+
+// Выполняет одну итерацию сервиса.
+func runTick(s *Service) {
+	ctx, cancel := context.WithCancel(context.Background())
+	it := newIteration(cancel)
+
+	s.runIteration(ctx, make(chan struct{}), it)
+	cancel()
+	it.cancelPersist()
+}
+
+// Возвращает количество уведомлений, отправленных каждому пользователю.
+func sentCounts(sender *memory.Sender) map[user.Id]int {
+	res := make(map[user.Id]int)
+	for _, p := range sender.Sent(testAppId) {
+		res[p.UserId]++
+	}
+	return res
+}
@@ -1,90 +1,43 @@
 package service
 
 import (
+	"context"
+	"github.com/getsentry/sentry-go"
 	"github.com/wolframdeus/noitifications-service/internal/errors"
 	"github.com/wolframdeus/noitifications-service/internal/notification"
-	"github.com/wolframdeus/noitifications-service/internal/user"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"strconv"
 )
 
-// Выполняет отправку уведомлений пользователям.
+// Выполняет отправку уведомлений пользователям от лица приложения задачи.
+// Ошибки отправки отдельных пачек уведомлений передаются в Sentry.
 func (s *Service) sendNotifications(
+	ctx context.Context,
+	t *task.Task,
 	params []notification.Params,
 ) (*notification.SendResult, *errors.ServiceError) {
-	// Создаем карту, в которой в качестве ключа будет сообщение, а в качестве
-	// значения - список батчей из идентификаторов пользователей.
-	// Пример: { "Привет Вася!": [[1, 2, 3], [92, 11, 2983, 22]] }
-	batches := make(map[string][][]user.Id)
-
-	for _, p := range params {
-		// Отрезаем все символы после 256-ого и вставляем в конце 3 точки. Это
-		// единственное адекватное решение, которые мы здесь можем использовать.
-		if len(p.Message) > 256 {
-			p.Message = p.Message[0:253] + "..."
-		}
-
-		// Получаем список всех пользователей с таким сообщением.
-		userIds, ok := batches[p.Message]
-		if !ok {
-			batches[p.Message] = [][]user.Id{{p.UserId}}
-			continue
-		}
-
-		// Получаем последний пачку с мыслью о том, что туда можно будет добавить
-		// этого пользователя.
-		batch := userIds[len(userIds)-1]
-
-		// Если эта пачка уже переполнена, то мы добавляем новую.
-		if len(batch) == SendNotificationUsersLimit {
-			batches[p.Message] = append(batches[p.Message], []user.Id{p.UserId})
-			continue
-		}
-		userIds[len(userIds)-1] = append(batch, p.UserId)
+	result, err := s.safeSend(ctx, t.AppId, params)
+	if err != nil {
+		return nil, err
 	}
 
-	var result *notification.SendResult
-
-	// Пробегаемся по каждой пачке и рассылаем уведомления.
-	for message, userIds := range batches {
-		for _, b := range userIds {
-			// TODO: Скорее всего это можно делать в отдельных горутинах.
-			// TODO: Не используется fragment :(
-			res, err := s.vk.NotificationsSendMessage(map[string]interface{}{
-				"user_ids": b,
-				"message":  message,
-			})
-
-			// Если произошла ошибка внутреннего характера, добавляем пользователей
-			// в соответствующий раздел.
-			if err != nil {
-				result.InternalError = append(result.InternalError, b...)
-			}
-
-			// Пробегаемся по каждому пользователю и добавляем его в свой раздел.
-			for _, r := range res {
-				uid := user.Id(r.UserID)
-
-				if r.Status {
-					result.Success = append(result.Success, uid)
-				} else {
-					// Спецификация ошибок:
-					// https://dev.vk.com/method/notifications.sendMessage#Результат
-					switch r.Error.Code {
-					case 1, 4:
-						result.NotificationsDisabled = append(result.NotificationsDisabled, uid)
-					case 2:
-						result.HourRateLimitReached = append(result.HourRateLimitReached, uid)
-					case 3:
-						result.DayRateLimitReached = append(result.DayRateLimitReached, uid)
-					default:
-						result.UnknownError = append(result.UnknownError, uid)
-					}
-				}
-			}
-		}
+	for _, e := range result.Errors {
+		s.captureServiceError(errors.NewServiceError(e.Original), &CaptureOptions{
+			Tags: map[string]string{
+				"app-id":     strconv.Itoa(int(t.AppId)),
+				"task-id":    strconv.Itoa(int(t.Id)),
+				"batch-size": strconv.Itoa(e.BatchSize),
+				"error-code": strconv.Itoa(e.Code),
+			},
+			Contexts: map[string]interface{}{
+				"Batch": map[string]interface{}{
+					"message":  e.Message,
+					"fragment": e.Fragment,
+					"userIds":  e.UserIds,
+				},
+			},
+			Level: sentry.LevelError,
+		})
 	}
-
-	// TODO: Как-то логировать ошибки, которые возвращаются от API ВКонтакте,
-	//  чтобы понимать, что что-то не так.
-
 	return result, nil
 }
@@ -0,0 +1,302 @@
+package service
+
+import (
+	"context"
+	"github.com/getsentry/sentry-go"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// Максимальное количество уведомлений для повторной отправки, которое
+	// обрабатывается за одну итерацию.
+	retryQueueDrainLimit = 1000
+)
+
+// Ключ, описывающий уведомление задачи для конкретного пользователя.
+type deliveryKey struct {
+	appId  appid.Id
+	taskId taskid.Id
+	userId user.Id
+}
+
+// Ключ, описывающий задачу приложения.
+type taskKey struct {
+	appId  appid.Id
+	taskId taskid.Id
+}
+
+// Возвращает множество уведомлений, которые ожидают повторной отправки
+// указанным пользователям, в том числе уведомлений, для которых исчерпаны
+// попытки отправки. Таким пользователям уведомления этих задач в рамках
+// обычной выборки не отправляются.
+func (s *Service) getPendingRetries(ctx context.Context, users []user.User) map[deliveryKey]bool {
+	userIds := make([]user.Id, len(users))
+	for i, u := range users {
+		userIds[i] = u.Id
+	}
+
+	items, err := s.safeGetRetryItemsByUsers(ctx, userIds)
+	if err != nil {
+		return nil
+	}
+
+	res := make(map[deliveryKey]bool, len(items))
+	for _, item := range items {
+		res[deliveryKey{appId: item.AppId, taskId: item.TaskId, userId: item.UserId}] = true
+	}
+	return res
+}
+
+// Добавляет в очередь повторной отправки уведомления задачи, отправка
+// которых завершилась временной ошибкой.
+func (s *Service) enqueueRetries(
+	ctx context.Context,
+	t *task.Task,
+	users []user.User,
+	params []notification.Params,
+	result *notification.SendResult,
+	now time.Time,
+) {
+	failed := append(append([]user.Id(nil), result.InternalError...), result.UnknownError...)
+	if len(failed) == 0 {
+		return
+	}
+
+	usersMap := make(map[user.Id]user.User, len(users))
+	for _, u := range users {
+		usersMap[u.Id] = u
+	}
+	paramsMap := make(map[user.Id]notification.Params, len(params))
+	for _, p := range params {
+		paramsMap[p.UserId] = p
+	}
+
+	items := make([]retry.Item, 0, len(failed))
+	for _, uid := range failed {
+		p := paramsMap[uid]
+		u := usersMap[uid]
+
+		item := retry.NewItem(uid, t.AppId, t.Id, p.Message, p.Fragment, t.GetWindowEnd(u.TimezoneAt(now), now))
+		item.Attempts = 1
+
+		if item.Attempts >= s.sendRetryPolicy.MaxAttempts {
+			s.deadLetter(item)
+		} else {
+			item.NextAttemptAt = now.Add(s.sendRetryPolicy.Delay(2))
+		}
+		items = append(items, *item)
+	}
+	s.safeSaveRetryItems(ctx, items)
+}
+
+// Выполняет повторную отправку уведомлений, время следующей попытки
+// которых уже наступило. Уведомления, промежуток отправки которых
+// завершился, а также уведомления, отправка которых больше не разрешена
+// пользователем или ограничениями задачи и приложения, удаляются из
+// очереди. Уведомления, для которых исчерпаны попытки, остаются в очереди
+// до завершения промежутка отправки, но больше не отправляются.
+func (s *Service) drainRetryQueue(ctx context.Context, it *iteration, tasks []task.Task, now time.Time) {
+	items, err := s.safeGetDueRetryItems(ctx, now, retryQueueDrainLimit)
+	if err != nil || len(items) == 0 {
+		return
+	}
+
+	var toDelete []string
+	groups := make(map[taskKey][]retry.Item)
+
+	for _, item := range items {
+		if item.ExpiresAt.Before(now) {
+			toDelete = append(toDelete, item.Id)
+			continue
+		}
+		if item.Dead {
+			continue
+		}
+		key := taskKey{appId: item.AppId, taskId: item.TaskId}
+		groups[key] = append(groups[key], item)
+	}
+
+	// Получаем актуальное состояние пользователей, чтобы применить к ним те
+	// же ограничения, что и при обычной отправке.
+	userIds := make([]user.Id, 0, len(items))
+	for _, group := range groups {
+		for _, item := range group {
+			userIds = append(userIds, item.UserId)
+		}
+	}
+	users, err := s.safeGetUsers(ctx, userIds)
+	if err != nil {
+		return
+	}
+	usersMap := make(map[user.Id]user.User, len(users))
+	for _, u := range users {
+		usersMap[u.Id] = u
+	}
+
+	// Обрабатываем задачи в порядке убывания приоритета, чтобы ограничения
+	// приложения в первую очередь расходовались более важными задачами.
+	keys := make([]taskKey, 0, len(groups))
+	for key := range groups {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a := findTask(tasks, keys[i].appId, keys[i].taskId)
+		b := findTask(tasks, keys[j].appId, keys[j].taskId)
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.Priority > b.Priority
+	})
+	budgets := make(map[appid.Id]*appBudget)
+
+	for _, key := range keys {
+		if ctx.Err() != nil {
+			break
+		}
+		group := groups[key]
+
+		// Задача могла быть удалена или приостановлена, отправлять её
+		// уведомления не нужно. Уведомления приостановленной задачи остаются
+		// в очереди до её возобновления или завершения промежутка отправки.
+		t := findTask(tasks, key.appId, key.taskId)
+		if t == nil {
+			if s.tasks.contains(key.appId, key.taskId) {
+				continue
+			}
+			for _, item := range group {
+				toDelete = append(toDelete, item.Id)
+			}
+			continue
+		}
+
+		budget, ok := budgets[key.appId]
+		if !ok {
+			budget = s.newAppBudget(key.appId, now)
+			budgets[key.appId] = budget
+		}
+
+		// Уведомления, отправка которых больше не разрешена, удаляем.
+		allowed := make([]retry.Item, 0, len(group))
+		for _, item := range group {
+			u, ok := usersMap[item.UserId]
+			if !ok || !s.allowsRetry(t, budget, &u, now) {
+				toDelete = append(toDelete, item.Id)
+				continue
+			}
+			allowed = append(allowed, item)
+		}
+		if len(allowed) == 0 {
+			continue
+		}
+		group = allowed
+
+		params := make([]notification.Params, len(group))
+		for i, item := range group {
+			params[i] = notification.Params{
+				UserId:   item.UserId,
+				Message:  item.Message,
+				Fragment: item.Fragment,
+			}
+		}
+
+		// Определяем пользователей, отправка которым снова не удалась. В
+		// случае ошибки отправки такими считаются все пользователи.
+		failed := make(map[user.Id]bool)
+		result, sendErr := s.sendNotifications(ctx, t, params)
+
+		// Уведомления уже отправлены, поэтому результаты сохраняем даже в
+		// случае отмены контекста итерации.
+		persistCtx, cancelPersist := it.newPersistContext()
+
+		if sendErr != nil {
+			for _, item := range group {
+				failed[item.UserId] = true
+			}
+		} else {
+			budget.add(result.Success)
+			s.addCampaignSendResult(key, result)
+			s.safeSaveSendResult(persistCtx, result, params, t.AppId, t.Id, s.clock.Now(), t.GetHistoryLimit())
+
+			for _, uid := range result.InternalError {
+				failed[uid] = true
+			}
+			for _, uid := range result.UnknownError {
+				failed[uid] = true
+			}
+		}
+
+		var retries []retry.Item
+		for _, item := range group {
+			if !failed[item.UserId] {
+				toDelete = append(toDelete, item.Id)
+				continue
+			}
+			item.Attempts++
+
+			if item.Attempts >= s.sendRetryPolicy.MaxAttempts {
+				s.deadLetter(&item)
+			} else {
+				item.NextAttemptAt = now.Add(s.sendRetryPolicy.Delay(item.Attempts + 1))
+			}
+			retries = append(retries, item)
+		}
+		s.safeSaveRetryItems(persistCtx, retries)
+		cancelPersist()
+	}
+
+	persistCtx, cancelPersist := it.newPersistContext()
+	defer cancelPersist()
+
+	s.safeDeleteRetryItems(persistCtx, toDelete)
+}
+
+// Отмечает уведомление как то, для которого исчерпаны попытки отправки, и
+// сообщает о нём. Запись хранится до завершения промежутка отправки: в
+// этот момент наступает время следующей попытки, и запись удаляется при
+// обработке очереди.
+func (s *Service) deadLetter(item *retry.Item) {
+	item.Dead = true
+	item.NextAttemptAt = item.ExpiresAt
+
+	s.captureDeadLetter(item)
+}
+
+// Сообщает об уведомлении, для которого исчерпаны попытки повторной
+// отправки.
+func (s *Service) captureDeadLetter(item *retry.Item) {
+	s.captureServiceError(customerror.NewServiceError(ErrRetryAttemptsExhausted), &CaptureOptions{
+		Tags: map[string]string{
+			"app-id":  strconv.Itoa(int(item.AppId)),
+			"task-id": strconv.Itoa(int(item.TaskId)),
+		},
+		Contexts: map[string]interface{}{
+			"Item": map[string]interface{}{
+				"userId":   item.UserId,
+				"message":  item.Message,
+				"fragment": item.Fragment,
+				"attempts": item.Attempts,
+			},
+		},
+		Level: sentry.LevelWarning,
+	})
+}
+
+// Возвращает ссылку на задачу с указанными идентификаторами из списка
+// задач. В случае, если задача не найдена, возвращает nil.
+func findTask(tasks []task.Task, appId appid.Id, taskId taskid.Id) *task.Task {
+	for i := range tasks {
+		if tasks[i].AppId == appId && tasks[i].Id == taskId {
+			return &tasks[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/clock"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
+	"github.com/wolframdeus/noitifications-service/internal/senders/memory"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"testing"
+	"time"
+)
+
+func TestDrainRetryQueueInactiveTask(t *testing.T) {
+	tests := []struct {
+		name string
+		// Изменяет состояние задачи перед обработкой очереди.
+		change func(s *Service) error
+		// Должна ли запись остаться в очереди.
+		kept bool
+	}{
+		{
+			name: "задача приостановлена",
+			change: func(s *Service) error {
+				return s.PauseTask(testAppId, 1)
+			},
+			kept: true,
+		},
+		{
+			name: "задача удалена",
+			change: func(s *Service) error {
+				return s.RemoveTask(testAppId, 1)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			now := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+			c := clock.NewFake(now)
+
+			p := newFakeProvider(10)
+			p.addUsers(0, 1)
+			item := retry.NewItem(1, testAppId, 1, "message", "", now.Add(time.Hour))
+			item.Attempts = 1
+			_ = p.SaveRetryItems(ctx, []retry.Item{*item})
+
+			sender := memory.New()
+			s := newTestService(t, p, sender, NewOptions{Clock: c}, newSendingTask(1, internal.Time{Hours: 10}, internal.Time{Hours: 12}))
+			if err := tt.change(s); err != nil {
+				t.Fatalf("неожиданная ошибка: %v", err)
+			}
+			runTick(s)
+
+			items, _ := p.GetRetryItemsByUsers(ctx, []user.Id{1})
+			if tt.kept != (len(items) == 1) {
+				t.Fatalf("ожидалось сохранение записи: %v, получено %+v", tt.kept, items)
+			}
+			if !tt.kept {
+				return
+			}
+
+			// После возобновления задачи уведомление отправляется.
+			if err := s.ResumeTask(testAppId, 1); err != nil {
+				t.Fatalf("неожиданная ошибка: %v", err)
+			}
+			c.Add(time.Minute)
+			runTick(s)
+
+			if sent := sender.Sent(testAppId); len(sent) != 1 || sent[0].Message != "message" {
+				t.Errorf("ожидалась повторная отправка уведомления, получено %+v", sent)
+			}
+			if items, _ := p.GetRetryItemsByUsers(ctx, []user.Id{1}); len(items) != 0 {
+				t.Errorf("запись должна быть удалена, получено %+v", items)
+			}
+		})
+	}
+}
+
+// Проверяет, что после исчерпания попыток отправки уведомление больше не
+// отправляется до завершения промежутка отправки, после чего запись о нём
+// удаляется.
+func TestDeadLetterExcludesUser(t *testing.T) {
+	p := newFakeProvider(10)
+	p.addUsers(0, 1)
+	c := clock.NewFake(time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC))
+	sender := &failingSender{attempts: make(map[user.Id]int)}
+	s := newTestService(t, p, sender, NewOptions{
+		Clock:           c,
+		SendRetryPolicy: &retry.Policy{MaxAttempts: 2, InitialDelay: time.Minute, Multiplier: 1},
+	}, newSendingTask(1, internal.Time{Hours: 10}, internal.Time{Hours: 12}))
+
+	// Первая попытка выполняется обычной выборкой, вторая - при обработке
+	// очереди повторной отправки. Последующие итерации промежутка не должны
+	// отправлять уведомление заново.
+	for i := 0; i < 10; i++ {
+		runTick(s)
+		c.Add(time.Minute)
+	}
+	if sender.attempts[1] != 2 {
+		t.Errorf("ожидалось 2 попытки отправки, выполнено %d", sender.attempts[1])
+	}
+
+	items, _ := p.GetRetryItemsByUsers(context.Background(), []user.Id{1})
+	if len(items) != 1 || !items[0].Dead {
+		t.Fatalf("ожидалась запись с исчерпанными попытками, получено %+v", items)
+	}
+
+	// После завершения промежутка отправки запись удаляется.
+	c.Set(items[0].ExpiresAt.Add(time.Minute))
+	runTick(s)
+
+	if items, _ := p.GetRetryItemsByUsers(context.Background(), []user.Id{1}); len(items) != 0 {
+		t.Errorf("запись должна быть удалена, получено %+v", items)
+	}
+	if sender.attempts[1] != 2 {
+		t.Errorf("ожидалось 2 попытки отправки, выполнено %d", sender.attempts[1])
+	}
+}
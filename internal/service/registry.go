@@ -0,0 +1,135 @@
+package service
+
+import (
+	"fmt"
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"sync"
+)
+
+// Описывает зарегистрированную в сервисе задачу.
+type registryItem struct {
+	task task.Task
+	// Приостановлена ли задача. Приостановленные задачи не выполняются.
+	paused bool
+}
+
+// Потокобезопасный реестр задач сервиса. Итерации работают со снимком
+// реестра, поэтому изменения вступают в силу со следующей итерации.
+type taskRegistry struct {
+	mu    sync.RWMutex
+	items []registryItem
+}
+
+// Возвращает индекс задачи в реестре или -1 в случае, если задачи нет.
+// Вызывается под мьютексом.
+func (r *taskRegistry) indexOf(appId appid.Id, taskId taskid.Id) int {
+	for i, item := range r.items {
+		if item.task.AppId == appId && item.task.Id == taskId {
+			return i
+		}
+	}
+	return -1
+}
+
+// Добавляет задачи в реестр. В случае, если какая-либо задача некорректна,
+// уже зарегистрирована или указана несколько раз, ни одна из задач не
+// добавляется.
+func (r *taskRegistry) add(tasks ...task.Task) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	added := make(map[taskKey]bool, len(tasks))
+
+	for _, t := range tasks {
+		key := taskKey{appId: t.AppId, taskId: t.Id}
+
+		if err := t.Validate(); err != nil {
+			return newTaskRegistryError(t.AppId, t.Id, err)
+		}
+		if added[key] || r.indexOf(t.AppId, t.Id) >= 0 {
+			return newTaskRegistryError(t.AppId, t.Id, ErrTaskAlreadyExists)
+		}
+		added[key] = true
+	}
+	for _, t := range tasks {
+		r.items = append(r.items, registryItem{task: t})
+	}
+	return nil
+}
+
+// Удаляет задачу из реестра.
+func (r *taskRegistry) remove(appId appid.Id, taskId taskid.Id) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(appId, taskId)
+	if i < 0 {
+		return newTaskRegistryError(appId, taskId, ErrTaskNotFound)
+	}
+	r.items = append(r.items[:i], r.items[i+1:]...)
+
+	return nil
+}
+
+// Заменяет зарегистрированную задачу с тем же приложением и
+// идентификатором. Состояние приостановки задачи сохраняется.
+func (r *taskRegistry) replace(t task.Task) error {
+	if err := t.Validate(); err != nil {
+		return newTaskRegistryError(t.AppId, t.Id, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(t.AppId, t.Id)
+	if i < 0 {
+		return newTaskRegistryError(t.AppId, t.Id, ErrTaskNotFound)
+	}
+	r.items[i].task = t
+
+	return nil
+}
+
+// Изменяет состояние приостановки задачи.
+func (r *taskRegistry) setPaused(appId appid.Id, taskId taskid.Id, paused bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.indexOf(appId, taskId)
+	if i < 0 {
+		return newTaskRegistryError(appId, taskId, ErrTaskNotFound)
+	}
+	r.items[i].paused = paused
+
+	return nil
+}
+
+// Возвращает true в случае, если задача зарегистрирована, в том числе
+// если она приостановлена.
+func (r *taskRegistry) contains(appId appid.Id, taskId taskid.Id) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.indexOf(appId, taskId) >= 0
+}
+
+// Возвращает копию списка выполняемых задач.
+func (r *taskRegistry) snapshot() []task.Task {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	res := make([]task.Task, 0, len(r.items))
+
+	for _, item := range r.items {
+		if !item.paused {
+			res = append(res, item.task)
+		}
+	}
+	return res
+}
+
+// Создает ошибку реестра задач для указанной задачи.
+func newTaskRegistryError(appId appid.Id, taskId taskid.Id, err error) error {
+	return fmt.Errorf("задача %d приложения %d: %w", taskId, appId, err)
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"errors"
+	"github.com/wolframdeus/noitifications-service/internal"
+	"github.com/wolframdeus/noitifications-service/internal/senders/memory"
+	"github.com/wolframdeus/noitifications-service/internal/task"
+	"testing"
+)
+
+// Проверяет, что некорректные задачи не добавляются и не заменяют
+// зарегистрированные.
+func TestTaskRegistryValidation(t *testing.T) {
+	emptyWindow := newSendingTask(1, internal.Time{Hours: 10}, internal.Time{Hours: 10})
+	withoutProcess := *task.NewTask(1, testAppId, internal.Window{
+		From: internal.Time{Hours: 10},
+		To:   internal.Time{Hours: 12},
+	}, nil)
+
+	tests := []struct {
+		name string
+		task task.Task
+		err  error
+	}{
+		{name: "пустой промежуток", task: emptyWindow, err: internal.ErrEmptyWindow},
+		{name: "без функции обработки", task: withoutProcess, err: task.ErrProcessMissing},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(t, newFakeProvider(10), memory.New(), NewOptions{})
+
+			if err := s.AddTask(tt.task); !errors.Is(err, tt.err) {
+				t.Errorf("добавление: ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+			if err := s.AddTask(newSendingTask(1, internal.Time{Hours: 10}, internal.Time{Hours: 12})); err != nil {
+				t.Fatalf("неожиданная ошибка: %v", err)
+			}
+			if err := s.ReplaceTask(tt.task); !errors.Is(err, tt.err) {
+				t.Errorf("замена: ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+		})
+	}
+}
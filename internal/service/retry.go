@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"time"
+)
+
+// Выполняет операцию с повторными попытками согласно политике повторного
+// выполнения сервиса. Повторно выполняются только операции, завершившиеся
+// временной ошибкой. Возвращает ошибку последней попытки.
+func (s *Service) withRetry(
+	ctx context.Context,
+	operation func() *customerror.ServiceError,
+) *customerror.ServiceError {
+	var err *customerror.ServiceError
+
+	for attempt := 1; ; attempt++ {
+		if delay := s.retryPolicy.Delay(attempt); delay > 0 {
+			timer := time.NewTimer(delay)
+
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+
+		err = operation()
+		if err == nil || !err.Retryable || ctx.Err() != nil {
+			return err
+		}
+		if attempt >= s.retryPolicy.MaxAttempts {
+			return err
+		}
+	}
+}
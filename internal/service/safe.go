@@ -1,12 +1,15 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/checkpoint"
 	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
 	"github.com/wolframdeus/noitifications-service/internal/notification"
 	"github.com/wolframdeus/noitifications-service/internal/providers"
+	"github.com/wolframdeus/noitifications-service/internal/retry"
 	"github.com/wolframdeus/noitifications-service/internal/task"
 	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/timezone"
@@ -28,6 +31,7 @@ func (s *Service) recoverServiceError(e interface{}) *customerror.ServiceError {
 
 // В безопасном режиме вызывает функцию SetAllowStatusForUser провайдера.
 func (s *Service) safeSetAllowStatusForUser(
+	ctx context.Context,
 	userId user.Id,
 	appId appid.Id,
 	allowed bool,
@@ -53,14 +57,17 @@ func (s *Service) safeSetAllowStatusForUser(
 		}
 	}()
 
-	err = s.provider.SetAllowStatusForUser(userId, appId, allowed, user)
+	err = s.provider.SetAllowStatusForUser(ctx, userId, appId, allowed, user)
 	return
 }
 
 // В безопасном режиме вызывает функцию GetUsersByTimezones провайдера.
 func (s *Service) safeGetUsersByTimezones(
+	ctx context.Context,
+	appIds []appid.Id,
 	tz []timezone.Range,
 	cursor user.Id,
+	now time.Time,
 ) (res *providers.GetUsersByTimezonesResult, err *customerror.ServiceError) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -72,24 +79,29 @@ func (s *Service) safeGetUsersByTimezones(
 			s.captureServiceError(err, &CaptureOptions{
 				Contexts: map[string]interface{}{
 					"Parameters": map[string]interface{}{
+						"appIds": appIds,
 						"tz":     tz,
 						"cursor": cursor,
+						"now":    now,
 					},
 				},
 			})
 		}
 	}()
 
-	res, err = s.provider.GetUsersByTimezones(tz, cursor)
+	res, err = s.provider.GetUsersByTimezones(ctx, appIds, tz, cursor, now)
 	return
 }
 
 // В безопасном режиме вызывает функцию SaveSendResult провайдера.
 func (s *Service) safeSaveSendResult(
+	ctx context.Context,
 	results *notification.SendResult,
+	params []notification.Params,
 	appId appid.Id,
 	taskId taskid.Id,
 	date time.Time,
+	historyLimit uint,
 ) (err *customerror.ServiceError) {
 	defer func() {
 		if e := recover(); e != nil {
@@ -101,17 +113,19 @@ func (s *Service) safeSaveSendResult(
 			s.captureServiceError(err, &CaptureOptions{
 				Contexts: map[string]interface{}{
 					"Parameters": map[string]interface{}{
-						"results": results,
-						"appId":   appId,
-						"taskId":  taskId,
-						"date":    date,
+						"results":      results,
+						"params":       params,
+						"appId":        appId,
+						"taskId":       taskId,
+						"date":         date,
+						"historyLimit": historyLimit,
 					},
 				},
 			})
 		}
 	}()
 
-	err = s.provider.SaveSendResult(results, appId, taskId, date)
+	err = s.provider.SaveSendResult(ctx, results, params, appId, taskId, date, historyLimit)
 	return
 }
 
@@ -147,3 +161,209 @@ func (s *Service) safeProcess(
 	res, err = t.Process(users)
 	return
 }
+
+// В безопасном режиме вызывает функцию Send способа доставки уведомлений.
+func (s *Service) safeSend(
+	ctx context.Context,
+	appId appid.Id,
+	params []notification.Params,
+) (res *notification.SendResult, err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"appId":  appId,
+						"params": params,
+					},
+				},
+			})
+		}
+	}()
+
+	res, err = s.sender.Send(ctx, appId, params)
+	return
+}
+
+// В безопасном режиме вызывает функцию SaveCheckpoint провайдера.
+func (s *Service) safeSaveCheckpoint(
+	ctx context.Context,
+	c *checkpoint.Checkpoint,
+) (err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"checkpoint": c,
+					},
+				},
+			})
+		}
+	}()
+
+	err = s.provider.SaveCheckpoint(ctx, c)
+	return
+}
+
+// В безопасном режиме вызывает функцию GetUnfinishedCheckpoint провайдера.
+func (s *Service) safeGetUnfinishedCheckpoint(
+	ctx context.Context,
+	since time.Time,
+) (res *checkpoint.Checkpoint, err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её.
+		if err != nil {
+			s.captureServiceError(err, nil)
+		}
+	}()
+
+	res, err = s.provider.GetUnfinishedCheckpoint(ctx, since)
+	return
+}
+
+// В безопасном режиме вызывает функцию SaveRetryItems провайдера.
+func (s *Service) safeSaveRetryItems(
+	ctx context.Context,
+	items []retry.Item,
+) (err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"items": items,
+					},
+				},
+			})
+		}
+	}()
+
+	err = s.provider.SaveRetryItems(ctx, items)
+	return
+}
+
+// В безопасном режиме вызывает функцию GetDueRetryItems провайдера.
+func (s *Service) safeGetDueRetryItems(
+	ctx context.Context,
+	now time.Time,
+	limit int64,
+) (res []retry.Item, err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"now":   now,
+						"limit": limit,
+					},
+				},
+			})
+		}
+	}()
+
+	res, err = s.provider.GetDueRetryItems(ctx, now, limit)
+	return
+}
+
+// В безопасном режиме вызывает функцию GetUsers провайдера.
+func (s *Service) safeGetUsers(
+	ctx context.Context,
+	userIds []user.Id,
+) (res []user.User, err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"userIds": userIds,
+					},
+				},
+			})
+		}
+	}()
+
+	res, err = s.provider.GetUsers(ctx, userIds)
+	return
+}
+
+// В безопасном режиме вызывает функцию GetRetryItemsByUsers провайдера.
+func (s *Service) safeGetRetryItemsByUsers(
+	ctx context.Context,
+	userIds []user.Id,
+) (res []retry.Item, err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"userIds": userIds,
+					},
+				},
+			})
+		}
+	}()
+
+	res, err = s.provider.GetRetryItemsByUsers(ctx, userIds)
+	return
+}
+
+// В безопасном режиме вызывает функцию DeleteRetryItems провайдера.
+func (s *Service) safeDeleteRetryItems(
+	ctx context.Context,
+	ids []string,
+) (err *customerror.ServiceError) {
+	defer func() {
+		if e := recover(); e != nil {
+			err = s.recoverServiceError(e)
+		}
+
+		// Если ошибка произошла, захватываем её и наполняем контекстными данными.
+		if err != nil {
+			s.captureServiceError(err, &CaptureOptions{
+				Contexts: map[string]interface{}{
+					"Parameters": map[string]interface{}{
+						"ids": ids,
+					},
+				},
+			})
+		}
+	}()
+
+	err = s.provider.DeleteRetryItems(ctx, ids)
+	return
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"github.com/getsentry/sentry-go"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"sync/atomic"
+	"time"
+)
+
+// StopResult описывает часть итерации, которая осталась необработанной
+// ввиду остановки сервиса.
+type StopResult struct {
+	// Была ли прервана выполнявшаяся итерация.
+	Interrupted bool
+	// Курсор, после которого пользователи не были обработаны. Имеет смысл
+	// только в случае, если итерация была прервана.
+	Cursor user.Id
+	// Количество пользователей, которые не были переданы в задачи.
+	UnprocessedUsers int
+	// Количество уведомлений, которые были сформированы задачами, но не были
+	// отправлены.
+	UnsentNotifications int
+	// Количество уведомлений, результаты отправки которых не удалось
+	// сохранить.
+	UnsavedResults int
+}
+
+// Выполняет планирование итераций сервиса до тех пор, пока не будет закрыт
+// канал stop.
+func (s *Service) schedule(ticker *time.Ticker, stop <-chan struct{}) {
+	if s.runOnStart {
+		s.tick(stop)
+	}
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			s.tick(stop)
+		}
+	}
+}
+
+// Запускает новую итерацию в отдельной горутине. В случае, если предыдущая
+// итерация ещё не завершилась, новая не запускается, а тик считается
+// пропущенным.
+func (s *Service) tick(stop <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Сервис мог быть остановлен одновременно с наступлением тика.
+	select {
+	case <-stop:
+		return
+	default:
+	}
+
+	if s.iteration != nil {
+		skipped := atomic.AddUint64(&s.skippedTicks, 1)
+
+		s.captureServiceError(customerror.NewServiceError(ErrTickSkipped), &CaptureOptions{
+			Contexts: map[string]interface{}{
+				"Scheduler": map[string]interface{}{
+					"tickInterval": s.tickInterval.String(),
+					"skippedTicks": skipped,
+				},
+			},
+			Level: sentry.LevelWarning,
+		})
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := newIteration(cancel)
+	s.iteration = it
+
+	go func() {
+		defer func() {
+			cancel()
+			it.cancelPersist()
+
+			s.mu.Lock()
+			s.iteration = nil
+			s.mu.Unlock()
+
+			close(it.done)
+		}()
+		s.runIteration(ctx, stop, it)
+	}()
+}
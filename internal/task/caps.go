@@ -0,0 +1,55 @@
+package task
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"time"
+)
+
+const (
+	// Длительность одного дня.
+	day = 24 * time.Hour
+	// Длительность одной недели.
+	week = 7 * day
+)
+
+// Caps описывает ограничения частоты отправки уведомления задачи одному
+// пользователю. Нулевые значения означают отсутствие ограничения.
+// Ограничения проверяются сервисом по истории отправки до передачи
+// пользователей в задачу.
+type Caps struct {
+	// Максимальное количество отправок за последние сутки.
+	MaxPerDay uint
+	// Максимальное количество отправок за последние 7 дней.
+	MaxPerWeek uint
+	// Максимальное количество отправок за всё время.
+	MaxTotal uint
+	// Минимальный промежуток между двумя отправками.
+	MinInterval time.Duration
+}
+
+// Allows возвращает true в случае, если состояние задачи пользователя
+// позволяет отправить ему уведомление в указанный момент времени.
+func (c *Caps) Allows(t user.Task, now time.Time) bool {
+	if c.MaxTotal > 0 && t.SendCount >= c.MaxTotal {
+		return false
+	}
+	if c.MinInterval > 0 && !t.LastSentAt.IsZero() && now.Sub(t.LastSentAt) < c.MinInterval {
+		return false
+	}
+	if c.MaxPerDay > 0 && uint(t.SentSince(now.Add(-day))) >= c.MaxPerDay {
+		return false
+	}
+	if c.MaxPerWeek > 0 && uint(t.SentSince(now.Add(-week))) >= c.MaxPerWeek {
+		return false
+	}
+	return true
+}
+
+// Возвращает количество записей в истории отправки, необходимое для
+// проверки ограничений.
+func (c *Caps) historyLimit() uint {
+	if c.MaxPerWeek > c.MaxPerDay {
+		return c.MaxPerWeek
+	}
+	return c.MaxPerDay
+}
@@ -10,14 +10,17 @@ import (
 	"github.com/wolframdeus/noitifications-service/internal/taskid"
 	"github.com/wolframdeus/noitifications-service/internal/timezone"
 	"github.com/wolframdeus/noitifications-service/internal/user"
-	"sort"
+	"time"
 )
 
-const (
-	// Количество минут в одном дне.
-	dayMinutes = 24 * 60
+var (
+	ErrProcessMissing = errors.New("не указана функция обработки пользователей")
 )
 
+// ProcessFunc описывает функцию обработки пользователей задачей. Помимо
+// часового пояса, каждый пользователь содержит своё состояние в приложениях,
+// в том числе историю отправки уведомлений задач, которое может
+// использоваться для принятия решения об отправке.
 type ProcessFunc func(users []user.User) ([]notification.Params, *customerror.TaskError)
 
 // Task описывает структуру любой задачи-уведомления.
@@ -26,47 +29,96 @@ type Task struct {
 	AppId appid.Id
 	// Идентификатор самой задачи.
 	Id taskid.Id
-	// Начало временного промежутка для отправки этого уведомления. Данное
-	// значение описывает локальное время пользователя.
-	From *internal.Time
-	// Конец временного промежутка для отправки этого уведомления. Данное
-	// значение описывает локальное время пользователя.
-	To      *internal.Time
+	// Промежуток локального времени пользователя, в который отправляется
+	// это уведомление.
+	Window internal.Window
+	// Минимальное количество записей в истории отправки задачи, хранимых
+	// для каждого пользователя. Провайдер хранит не меньше записей, чем
+	// его собственное ограничение.
+	HistoryLimit uint
+	// Ограничения частоты отправки уведомления одному пользователю.
+	Caps Caps
+	// Календарное расписание задачи. По умолчанию задача выполняется
+	// ежедневно.
+	Schedule Schedule
+	// Приоритет задачи. Задачи приложения с большим приоритетом
+	// обрабатываются раньше и первыми расходуют ограничения приложения.
+	Priority int
+	// Группа взаимоисключающих задач приложения. Пользователь передаётся
+	// только в одну задачу группы - с наибольшим приоритетом. Пустое
+	// значение означает, что задача не входит в группу.
+	Group   string
 	process ProcessFunc
 }
 
-// GetTimezones возвращает массив диапазонов часовых поясов, которые
-// соответствуют указанному в задаче времени. Результирующий массив
+// GetTimezones возвращает массив диапазонов часовых поясов, которые в
+// момент now соответствуют промежутку задачи, а локальная дата начала
+// промежутка отправки удовлетворяет расписанию задачи. Промежутки короче
+// minDuration расширяются до minDuration. Результирующий массив
 // отсортирован по возрастанию концов интервалов.
-func (s *Task) GetTimezones() []timezone.Range {
-	fromTz := s.From.GetTimezones()
-	toTz := s.To.GetTimezones()
-
-	if len(fromTz) != len(toTz) {
-		// Дополняем массивы часовых поясов необходимыми для создания интервалов
-		// значениями.
-		if len(fromTz) != 2 {
-			fromTz = append(fromTz, timezone.CutTimezone(fromTz[0]-dayMinutes))
-			fromTz[1], fromTz[0] = fromTz[0], fromTz[1]
-		}
-		if len(toTz) != 2 {
-			toTz = append(toTz, timezone.CutTimezone(toTz[0]+dayMinutes))
+func (s *Task) GetTimezones(now time.Time, minDuration time.Duration) []timezone.Range {
+	ranges := s.Window.GetTimezones(now, minDuration)
+	res := make([]timezone.Range, 0, len(ranges))
+
+	for _, r := range ranges {
+		// Во всех часовых поясах интервала промежуток отправки начался в одну
+		// и ту же локальную дату, поэтому расписание достаточно проверить для
+		// начала интервала.
+		if !s.Schedule.Matches(s.GetLocalWindowStart(r.From, now)) {
+			continue
 		}
+		res = append(res, r)
 	}
-	res := make([]timezone.Range, len(fromTz))
+	return res
+}
 
-	for i := 0; i < len(fromTz); i++ {
-		res[i] = *timezone.NewRange(fromTz[i], toTz[i])
+// GetWindowStart возвращает момент начала текущего (или последнего
+// завершившегося) промежутка отправки уведомления для пользователя с
+// указанным часовым поясом.
+func (s *Task) GetWindowStart(tz timezone.Timezone, now time.Time) time.Time {
+	return s.GetLocalWindowStart(tz, now).Add(-time.Duration(tz) * time.Minute)
+}
+
+// GetLocalWindowStart возвращает локальные дату и время начала текущего
+// (или последнего завершившегося) промежутка отправки уведомления для
+// пользователя с указанным часовым поясом. Результат выражен в UTC, то есть
+// его поля описывают локальные дату и время пользователя.
+func (s *Task) GetLocalWindowStart(tz timezone.Timezone, now time.Time) time.Time {
+	local := now.UTC().Add(time.Duration(tz) * time.Minute)
+	start := time.Date(
+		local.Year(),
+		local.Month(),
+		local.Day(),
+		int(s.Window.From.Hours),
+		int(s.Window.From.Minutes),
+		int(s.Window.From.Seconds),
+		0,
+		time.UTC,
+	)
+
+	// Начало промежутка ещё не наступило, значит текущий промежуток начался
+	// в предыдущий день.
+	if start.After(local) {
+		start = start.Add(-24 * time.Hour)
 	}
+	return start
+}
 
-	// Если получилось так, что количество интервалов стало больше 1, мы
-	// отсортируем их по возрастанию их концов.
-	if len(res) > 1 {
-		sort.SliceStable(res, func(i, j int) bool {
-			return res[i].To < res[j].To
-		})
+// GetWindowEnd возвращает момент окончания текущего (или последнего
+// завершившегося) промежутка отправки уведомления для пользователя с
+// указанным часовым поясом.
+func (s *Task) GetWindowEnd(tz timezone.Timezone, now time.Time) time.Time {
+	return s.GetWindowStart(tz, now).Add(s.Window.Duration())
+}
+
+// GetHistoryLimit возвращает минимальное количество записей в истории
+// отправки задачи, достаточное для проверки ограничений частоты отправки.
+// Провайдер хранит не меньше записей, чем его собственное ограничение.
+func (s *Task) GetHistoryLimit() uint {
+	if limit := s.Caps.historyLimit(); limit > s.HistoryLimit {
+		return limit
 	}
-	return res
+	return s.HistoryLimit
 }
 
 // Process принимает на вход список пользователей и проверяет, необходимо ли
@@ -89,19 +141,29 @@ func (s *Task) Process(users []user.User) (params []notification.Params, err *cu
 	return
 }
 
+// Validate проверяет, что промежуток отправки задачи корректен и указана
+// функция обработки пользователей.
+func (s *Task) Validate() error {
+	if err := s.Window.Validate(); err != nil {
+		return err
+	}
+	if s.process == nil {
+		return ErrProcessMissing
+	}
+	return nil
+}
+
 // NewTask возвращает ссылку на новый экземпляр Task.
 func NewTask(
 	id taskid.Id,
 	appId appid.Id,
-	from *internal.Time,
-	to *internal.Time,
+	window internal.Window,
 	process ProcessFunc,
 ) *Task {
 	return &Task{
 		AppId:   appId,
 		Id:      id,
-		From:    from,
-		To:      to,
+		Window:  window,
 		process: process,
 	}
 }
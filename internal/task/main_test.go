@@ -0,0 +1,189 @@
+package task
+
+import (
+	"errors"
+	"github.com/wolframdeus/noitifications-service/internal"
+	customerror "github.com/wolframdeus/noitifications-service/internal/errors"
+	"github.com/wolframdeus/noitifications-service/internal/notification"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"github.com/wolframdeus/noitifications-service/internal/user"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Ночной промежуток отправки с 22:00 до 02:00.
+var overnight = internal.Window{From: internal.Time{Hours: 22}, To: internal.Time{Hours: 2}}
+
+// Возвращает тестовый момент времени по Гринвичу. 1 января 2026 года -
+// четверг.
+func utc(day int, hours int, minutes int) time.Time {
+	return time.Date(2026, 1, day, hours, minutes, 0, 0, time.UTC)
+}
+
+func TestTaskGetLocalWindowStart(t *testing.T) {
+	tests := []struct {
+		name     string
+		tz       timezone.Timezone
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			name:     "промежуток начался в предыдущий день",
+			tz:       0,
+			now:      utc(1, 0, 30),
+			expected: time.Date(2025, 12, 31, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "промежуток начался в текущий день",
+			tz:       180,
+			now:      utc(1, 20, 0),
+			expected: time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "начало промежутка совпадает с текущим моментом",
+			tz:       0,
+			now:      utc(1, 22, 0),
+			expected: time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "последний завершившийся промежуток",
+			tz:       -600,
+			now:      utc(1, 0, 30),
+			expected: time.Date(2025, 12, 30, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "локальная дата отличается от даты по Гринвичу",
+			tz:       840,
+			now:      utc(1, 10, 0),
+			expected: time.Date(2026, 1, 1, 22, 0, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := NewTask(1, 1, overnight, nil)
+
+			if got := task.GetLocalWindowStart(tt.tz, tt.now); !got.Equal(tt.expected) {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, got)
+			}
+			// Момент начала промежутка отличается от локального на смещение
+			// часового пояса.
+			expected := tt.expected.Add(-time.Duration(tt.tz) * time.Minute)
+			if got := task.GetWindowStart(tt.tz, tt.now); !got.Equal(expected) {
+				t.Errorf("ожидалось начало промежутка %v, получено %v", expected, got)
+			}
+		})
+	}
+}
+
+func TestTaskGetTimezones(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule Schedule
+		now      time.Time
+		expected []timezone.Range
+	}{
+		{
+			name:     "без расписания",
+			now:      utc(1, 0, 30),
+			expected: []timezone.Range{{From: -150, To: 90}},
+		},
+		{
+			// Промежуток во всех часовых поясах начался в среду 31 декабря.
+			name:     "расписание по дате начала промежутка",
+			schedule: Schedule{Weekdays: Wednesday},
+			now:      utc(1, 0, 30),
+			expected: []timezone.Range{{From: -150, To: 90}},
+		},
+		{
+			name:     "расписание не совпадает с датой начала промежутка",
+			schedule: Schedule{Weekdays: Thursday},
+			now:      utc(1, 0, 30),
+			expected: []timezone.Range{},
+		},
+		{
+			name:     "расписание совпадает на следующий день",
+			schedule: Schedule{Weekdays: Thursday},
+			now:      utc(2, 0, 30),
+			expected: []timezone.Range{{From: -150, To: 90}},
+		},
+		{
+			// В интервале [-720, -630] промежуток начался 31 декабря, а в
+			// интервале [570, 810] - 1 января.
+			name:     "интервалы в разных локальных датах",
+			schedule: Schedule{Weekdays: Thursday},
+			now:      utc(1, 12, 30),
+			expected: []timezone.Range{{From: 570, To: 810}},
+		},
+		{
+			name:     "конкретная дата",
+			schedule: Schedule{Dates: []Date{NewDate(2025, time.December, 31)}},
+			now:      utc(1, 12, 30),
+			expected: []timezone.Range{{From: -720, To: -630}},
+		},
+		{
+			name:     "день месяца",
+			schedule: Schedule{MonthDays: []int{1, 31}},
+			now:      utc(1, 12, 30),
+			expected: []timezone.Range{{From: -720, To: -630}, {From: 570, To: 810}},
+		},
+		{
+			name:     "правила выполняются одновременно",
+			schedule: Schedule{Weekdays: Thursday, MonthDays: []int{31}},
+			now:      utc(1, 12, 30),
+			expected: []timezone.Range{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			task := NewTask(1, 1, overnight, nil)
+			task.Schedule = tt.schedule
+
+			if got := task.GetTimezones(tt.now, time.Minute); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestTaskValidate(t *testing.T) {
+	process := func([]user.User) ([]notification.Params, *customerror.TaskError) {
+		return nil, nil
+	}
+
+	tests := []struct {
+		name string
+		task *Task
+		err  error
+	}{
+		{
+			name: "корректная задача",
+			task: NewTask(1, 1, overnight, process),
+		},
+		{
+			name: "некорректный промежуток",
+			task: NewTask(1, 1, internal.Window{From: internal.Time{Hours: 25}, To: internal.Time{Hours: 2}}, process),
+			err:  internal.ErrInvalidHours,
+		},
+		{
+			name: "пустой промежуток",
+			task: NewTask(1, 1, internal.Window{From: internal.Time{Hours: 2}, To: internal.Time{Hours: 2}}, process),
+			err:  internal.ErrEmptyWindow,
+		},
+		{
+			name: "без функции обработки",
+			task: NewTask(1, 1, overnight, nil),
+			err:  ErrProcessMissing,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.task.Validate(); !errors.Is(err, tt.err) {
+				t.Errorf("ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package task
+
+import "time"
+
+// Weekdays описывает множество дней недели. Бит с номером time.Weekday
+// соответствует этому дню недели.
+type Weekdays uint8
+
+const (
+	Sunday    Weekdays = 1 << time.Sunday
+	Monday    Weekdays = 1 << time.Monday
+	Tuesday   Weekdays = 1 << time.Tuesday
+	Wednesday Weekdays = 1 << time.Wednesday
+	Thursday  Weekdays = 1 << time.Thursday
+	Friday    Weekdays = 1 << time.Friday
+	Saturday  Weekdays = 1 << time.Saturday
+
+	// WorkDays - дни недели с понедельника по пятницу.
+	WorkDays = Monday | Tuesday | Wednesday | Thursday | Friday
+	// Weekend - выходные дни.
+	Weekend = Saturday | Sunday
+)
+
+// Contains возвращает true в случае, если множество содержит указанный день
+// недели.
+func (w Weekdays) Contains(day time.Weekday) bool {
+	return w&(1<<day) != 0
+}
+
+// Date описывает календарную дату.
+type Date struct {
+	Year  int
+	Month time.Month
+	Day   int
+}
+
+// Equal возвращает true в случае, если дата совпадает с датой указанного
+// момента времени в его часовом поясе.
+func (d Date) Equal(date time.Time) bool {
+	y, m, day := date.Date()
+
+	return d.Year == y && d.Month == m && d.Day == day
+}
+
+// NewDate возвращает новый экземпляр Date.
+func NewDate(year int, month time.Month, day int) Date {
+	return Date{Year: year, Month: month, Day: day}
+}
+
+// Schedule описывает календарное расписание задачи. Расписание проверяется
+// по локальной дате пользователя, в которую начался промежуток отправки.
+// Пустые правила не ограничивают отправку, указанные правила должны
+// выполняться одновременно. Нулевое значение означает ежедневную отправку.
+type Schedule struct {
+	// Дни недели, в которые выполняется задача.
+	Weekdays Weekdays
+	// Дни месяца (от 1 до 31), в которые выполняется задача.
+	MonthDays []int
+	// Конкретные даты, в которые выполняется задача.
+	Dates []Date
+}
+
+// Matches возвращает true в случае, если задача должна выполняться в дату
+// указанного момента времени. Дата вычисляется в часовом поясе date.
+func (s *Schedule) Matches(date time.Time) bool {
+	if s.Weekdays != 0 && !s.Weekdays.Contains(date.Weekday()) {
+		return false
+	}
+	if len(s.MonthDays) > 0 && !containsInt(s.MonthDays, date.Day()) {
+		return false
+	}
+	if len(s.Dates) > 0 && !containsDate(s.Dates, date) {
+		return false
+	}
+	return true
+}
+
+// Возвращает true в случае, если срез содержит указанное значение.
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Возвращает true в случае, если срез содержит дату указанного момента
+// времени.
+func containsDate(dates []Date, date time.Time) bool {
+	for _, d := range dates {
+		if d.Equal(date) {
+			return true
+		}
+	}
+	return false
+}
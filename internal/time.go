@@ -1,8 +1,18 @@
 package internal
 
 import (
-	"github.com/wolframdeus/noitifications-service/internal/timezone"
-	"time"
+	"errors"
+)
+
+const (
+	// Количество секунд в одном дне.
+	daySeconds = 24 * 60 * 60
+)
+
+var (
+	ErrInvalidHours   = errors.New("часы должны находиться в диапазоне от 0 до 23")
+	ErrInvalidMinutes = errors.New("минуты должны находиться в диапазоне от 0 до 59")
+	ErrInvalidSeconds = errors.New("секунды должны находиться в диапазоне от 0 до 59")
 )
 
 // Time описывает структуру времени.
@@ -11,61 +21,49 @@ type Time struct {
 	Hours byte
 	// Минуты.
 	Minutes byte
+	// Секунды.
+	Seconds byte
 }
 
-// GetTimezones возвращает массив часовых поясов, в которых на данный момент
-// указанное время. В качестве результата возвращается массив из 1 или двух
-// значений отсортированных по возрастанию.
-func (t *Time) GetTimezones() []timezone.Timezone {
-	// Получаем текущую дату по Гринвичу.
-	now := time.Now().UTC()
-
-	// Получаем предполагаемую будущую дату с установленным временем из
-	// текущего экземпляра.
-	futureDate := t.insertInto(now)
-
-	// Создаём ссылку для сохранения даты на предыдущий день.
-	pastDate := time.Now()
-
-	// Переходим к проверке тех дат, с которыми будем далее работать. Вычисляем
-	// будущую и прошлую даты.
-	if futureDate.After(now) {
-		pastDate = t.insertInto(now.Add(-24 * time.Hour))
-	} else {
-		pastDate, futureDate = futureDate, t.insertInto(now.Add(24*time.Hour))
+// Validate проверяет, что время описывает корректное время суток.
+func (t *Time) Validate() error {
+	if t.Hours > 23 {
+		return ErrInvalidHours
 	}
-
-	// Находим значения часовых поясов.
-	futureTz := futureDate.Sub(now).Minutes()
-	pastTz := pastDate.Sub(now).Minutes()
-
-	res := make([]timezone.Timezone, 0, 2)
-
-	// Проверяем каждый из часовых поясов и добавляем в результирующий массив.
-	if timezone.IsValidTimezone(int(pastTz)) {
-		res = append(res, timezone.Timezone(pastTz))
+	if t.Minutes > 59 {
+		return ErrInvalidMinutes
 	}
-	if timezone.IsValidTimezone(int(futureTz)) {
-		res = append(res, timezone.Timezone(futureTz))
+	if t.Seconds > 59 {
+		return ErrInvalidSeconds
 	}
-	return res
+	return nil
+}
+
+// Before возвращает true в случае, если время наступает в течение суток
+// раньше указанного.
+func (t *Time) Before(other Time) bool {
+	return t.daySeconds() < other.daySeconds()
 }
 
-// Вставляет в указанный экземпляр даты текущие значения часов и минут.
-func (t *Time) insertInto(date time.Time) time.Time {
-	return time.Date(
-		date.Year(),
-		date.Month(),
-		date.Day(),
-		int(t.Hours),
-		int(t.Minutes),
-		date.Second(),
-		date.Nanosecond(),
-		date.Location(),
-	)
+// Возвращает количество секунд, прошедших с начала суток.
+func (t *Time) daySeconds() int {
+	return int(t.Hours)*3600 + int(t.Minutes)*60 + int(t.Seconds)
 }
 
-// NewTime возвращает ссылку на новый экземпляр Time.
-func NewTime(h byte, m byte) *Time {
-	return &Time{Hours: h, Minutes: m}
+// NewTime возвращает ссылку на новый экземпляр Time с указанными часами и
+// минутами. Возвращает ошибку в случае, если время некорректно.
+func NewTime(h byte, m byte) (*Time, error) {
+	return NewTimeWithSeconds(h, m, 0)
+}
+
+// NewTimeWithSeconds возвращает ссылку на новый экземпляр Time с указанными
+// часами, минутами и секундами. Возвращает ошибку в случае, если время
+// некорректно.
+func NewTimeWithSeconds(h byte, m byte, s byte) (*Time, error) {
+	t := &Time{Hours: h, Minutes: m, Seconds: s}
+
+	if err := t.Validate(); err != nil {
+		return nil, err
+	}
+	return t, nil
 }
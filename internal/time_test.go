@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewTimeWithSeconds(t *testing.T) {
+	tests := []struct {
+		name    string
+		hours   byte
+		minutes byte
+		seconds byte
+		err     error
+	}{
+		{name: "начало суток"},
+		{name: "конец суток", hours: 23, minutes: 59, seconds: 59},
+		{name: "некорректные часы", hours: 24, err: ErrInvalidHours},
+		{name: "некорректные минуты", hours: 10, minutes: 60, err: ErrInvalidMinutes},
+		{name: "некорректные секунды", hours: 10, seconds: 60, err: ErrInvalidSeconds},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewTimeWithSeconds(tt.hours, tt.minutes, tt.seconds)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			expected := Time{Hours: tt.hours, Minutes: tt.minutes, Seconds: tt.seconds}
+			if *got != expected {
+				t.Errorf("ожидалось %+v, получено %+v", expected, *got)
+			}
+		})
+	}
+}
+
+func TestNewTime(t *testing.T) {
+	tests := []struct {
+		name    string
+		hours   byte
+		minutes byte
+		err     error
+	}{
+		{name: "корректное время", hours: 19, minutes: 30},
+		{name: "некорректные часы", hours: 25, minutes: 10, err: ErrInvalidHours},
+		{name: "некорректные минуты", hours: 10, minutes: 70, err: ErrInvalidMinutes},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewTime(tt.hours, tt.minutes)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+			if tt.err != nil {
+				return
+			}
+			expected := Time{Hours: tt.hours, Minutes: tt.minutes}
+			if *got != expected {
+				t.Errorf("ожидалось %+v, получено %+v", expected, *got)
+			}
+		})
+	}
+}
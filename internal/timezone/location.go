@@ -0,0 +1,32 @@
+package timezone
+
+import (
+	"sync"
+	"time"
+)
+
+// Кэш загруженных часовых поясов IANA.
+var locations sync.Map
+
+// LoadLocation возвращает часовой пояс IANA с указанным наименованием,
+// например "Europe/Berlin". Загруженные часовые пояса кэшируются.
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locations.Store(name, loc)
+
+	return loc, nil
+}
+
+// At возвращает смещение часового пояса IANA в указанный момент времени с
+// учетом перехода на летнее время.
+func At(loc *time.Location, date time.Time) Timezone {
+	_, offset := date.In(loc).Zone()
+
+	return Timezone(offset / 60)
+}
@@ -0,0 +1,74 @@
+package user
+
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/taskid"
+	"time"
+)
+
+// HistoryItem описывает запись в истории отправки уведомления.
+type HistoryItem struct {
+	// Дата отправки уведомления.
+	SentAt time.Time
+	// Фрагмент, который был использован в уведомлении.
+	Fragment string
+}
+
+// Task описывает состояние задачи приложения для конкретного пользователя.
+type Task struct {
+	// Количество отправок уведомления этой задачи пользователю.
+	SendCount uint
+	// Дата последней отправки уведомления этой задачи. Нулевое значение
+	// означает, что уведомление ещё ни разу не отправлялось.
+	LastSentAt time.Time
+	// История отправки уведомления этой задачи. Последняя отправка находится
+	// в начале списка.
+	History []HistoryItem
+}
+
+// SentSince возвращает количество отправок уведомления, выполненных начиная
+// с указанного момента времени.
+func (t *Task) SentSince(date time.Time) int {
+	count := 0
+
+	for _, h := range t.History {
+		if h.SentAt.Before(date) {
+			break
+		}
+		count++
+	}
+	return count
+}
+
+// App описывает состояние приложения для конкретного пользователя.
+type App struct {
+	// Разрешена ли пользователю отправка уведомлений в этом приложении.
+	AreNotificationsEnabled bool
+	// Дата, до которой пользователю не отправляются уведомления приложения
+	// ввиду достижения лимита на их отправку.
+	CooldownUntil time.Time
+	// Состояния задач приложения.
+	Tasks map[taskid.Id]Task
+}
+
+// GetApp возвращает состояние приложения для пользователя.
+func (u *User) GetApp(appId appid.Id) App {
+	return u.Apps[appId]
+}
+
+// GetTask возвращает состояние задачи приложения для пользователя.
+func (u *User) GetTask(appId appid.Id, taskId taskid.Id) Task {
+	return u.Apps[appId].Tasks[taskId]
+}
+
+// IsNotificationsEnabled возвращает true в случае, если пользователь разрешил
+// отправку уведомлений от лица указанного приложения.
+func (u *User) IsNotificationsEnabled(appId appid.Id) bool {
+	return u.Apps[appId].AreNotificationsEnabled
+}
+
+// IsInCooldown возвращает true в случае, если в указанный момент времени
+// отправка уведомлений от лица приложения пользователю ограничена.
+func (u *User) IsInCooldown(appId appid.Id, now time.Time) bool {
+	return u.Apps[appId].CooldownUntil.After(now)
+}
@@ -1,6 +1,10 @@
 package user
 
-import "github.com/wolframdeus/noitifications-service/internal/timezone"
+import (
+	"github.com/wolframdeus/noitifications-service/internal/appid"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"time"
+)
 
 // Id описывает идентификатор пользователя ВКонтакте.
 type Id uint64
@@ -8,8 +12,29 @@ type Id uint64
 type User struct {
 	// Идентификатор пользователя.
 	Id Id
-	// Часовой пояс пользователя.
+	// Часовой пояс пользователя. В случае, если указан часовой пояс IANA,
+	// используется только при невозможности его загрузить.
 	Timezone timezone.Timezone
+	// Наименование часового пояса IANA пользователя, например
+	// "Europe/Berlin". Пустое значение означает, что используется
+	// фиксированное смещение Timezone.
+	Location string
+	// Состояния приложений пользователя.
+	Apps map[appid.Id]App
+}
+
+// TimezoneAt возвращает часовой пояс пользователя в указанный момент
+// времени. Для пользователей с часовым поясом IANA учитывается переход на
+// летнее время.
+func (u *User) TimezoneAt(date time.Time) timezone.Timezone {
+	if u.Location == "" {
+		return u.Timezone
+	}
+	loc, err := timezone.LoadLocation(u.Location)
+	if err != nil {
+		return u.Timezone
+	}
+	return timezone.At(loc, date)
 }
 
 // New возвращает ссылку на новый экземпляр пользователя.
@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"errors"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"math"
+	"sort"
+	"time"
+)
+
+var (
+	ErrEmptyWindow = errors.New("начало и конец промежутка совпадают")
+)
+
+// Window описывает ежедневный промежуток локального времени пользователя.
+// В случае, если конец промежутка наступает раньше его начала, промежуток
+// считается ночным, то есть заканчивается на следующий день.
+type Window struct {
+	// Начало промежутка.
+	From Time
+	// Конец промежутка.
+	To Time
+}
+
+// IsOvernight возвращает true в случае, если промежуток заканчивается на
+// следующий день.
+func (w *Window) IsOvernight() bool {
+	return w.To.Before(w.From)
+}
+
+// Duration возвращает длительность промежутка.
+func (w *Window) Duration() time.Duration {
+	seconds := (w.To.daySeconds() - w.From.daySeconds() + daySeconds) % daySeconds
+
+	return time.Duration(seconds) * time.Second
+}
+
+// Contains возвращает true в случае, если указанное локальное время
+// находится в промежутке. Границы промежутка включаются.
+func (w *Window) Contains(t Time) bool {
+	if w.IsOvernight() {
+		return !t.Before(w.From) || !w.To.Before(t)
+	}
+	return !t.Before(w.From) && !w.To.Before(t)
+}
+
+// GetTimezones возвращает массив диапазонов часовых поясов, в которых в
+// момент now локальное время находится в промежутке. Промежутки короче
+// minDuration расширяются до minDuration, поэтому при вызове метода не
+// реже, чем раз в minDuration, ни один часовой пояс не будет пропущен.
+// Результирующий массив отсортирован по возрастанию концов интервалов.
+func (w *Window) GetTimezones(now time.Time, minDuration time.Duration) []timezone.Range {
+	duration := w.Duration()
+	if duration < minDuration {
+		duration = minDuration
+	}
+	// Промежуток не может быть длиннее суток.
+	if duration >= daySeconds*time.Second {
+		duration = daySeconds*time.Second - time.Second
+	}
+
+	// Количество секунд, прошедших с начала суток по Гринвичу.
+	now = now.UTC()
+	elapsed := float64(now.Hour()*3600 + now.Minute()*60 + now.Second())
+
+	// Локальное время часового пояса tz находится в промежутке в случае,
+	// если начало промежутка наступило не более duration назад. Для каждого
+	// из соседних дней вычисляем подходящие часовые пояса.
+	start := (float64(w.From.daySeconds()) - elapsed) / 60
+	end := start + duration.Minutes()
+	res := make([]timezone.Range, 0, 2)
+
+	for day := -2; day <= 1; day++ {
+		from := timezone.Timezone(math.Ceil(start + float64(day*24*60)))
+		to := timezone.Timezone(math.Floor(end + float64(day*24*60)))
+
+		if to < timezone.MinTimezone || from > timezone.MaxTimezone {
+			continue
+		}
+		from, to = timezone.CutTimezone(from), timezone.CutTimezone(to)
+
+		if from > to {
+			continue
+		}
+		res = append(res, *timezone.NewRange(from, to))
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].To < res[j].To
+	})
+	return res
+}
+
+// Validate проверяет, что время начала и конца промежутка корректно и
+// они не совпадают.
+func (w *Window) Validate() error {
+	if err := w.From.Validate(); err != nil {
+		return err
+	}
+	if err := w.To.Validate(); err != nil {
+		return err
+	}
+	if w.From == w.To {
+		return ErrEmptyWindow
+	}
+	return nil
+}
+
+// NewWindow возвращает ссылку на новый экземпляр Window. Возвращает ошибку
+// в случае, если время начала или конца некорректно, либо они совпадают.
+func NewWindow(from *Time, to *Time) (*Window, error) {
+	w := &Window{From: *from, To: *to}
+
+	if err := w.Validate(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	"errors"
+	"github.com/wolframdeus/noitifications-service/internal/timezone"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Возвращает промежуток с указанными границами.
+func window(from Time, to Time) Window {
+	return Window{From: from, To: to}
+}
+
+func TestNewWindow(t *testing.T) {
+	tests := []struct {
+		name string
+		from Time
+		to   Time
+		err  error
+	}{
+		{name: "дневной промежуток", from: Time{Hours: 10}, to: Time{Hours: 12}},
+		{name: "ночной промежуток", from: Time{Hours: 22}, to: Time{Hours: 2}},
+		{name: "некорректное начало", from: Time{Hours: 24}, to: Time{Hours: 2}, err: ErrInvalidHours},
+		{name: "некорректный конец", from: Time{Hours: 22}, to: Time{Hours: 2, Seconds: 60}, err: ErrInvalidSeconds},
+		{name: "пустой промежуток", from: Time{Hours: 10}, to: Time{Hours: 10}, err: ErrEmptyWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewWindow(&tt.from, &tt.to)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("ожидалась ошибка %v, получено %v", tt.err, err)
+			}
+			if tt.err == nil && *got != window(tt.from, tt.to) {
+				t.Errorf("ожидалось %+v, получено %+v", window(tt.from, tt.to), *got)
+			}
+		})
+	}
+}
+
+func TestWindowDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   Window
+		expected time.Duration
+	}{
+		{
+			name:     "дневной промежуток",
+			window:   window(Time{Hours: 10}, Time{Hours: 12, Minutes: 30}),
+			expected: 150 * time.Minute,
+		},
+		{
+			name:     "ночной промежуток",
+			window:   window(Time{Hours: 22}, Time{Hours: 2}),
+			expected: 4 * time.Hour,
+		},
+		{
+			name:     "промежуток с секундами",
+			window:   window(Time{Hours: 23, Minutes: 59, Seconds: 50}, Time{Seconds: 20}),
+			expected: 30 * time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Duration(); got != tt.expected {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWindowContains(t *testing.T) {
+	tests := []struct {
+		name     string
+		window   Window
+		time     Time
+		expected bool
+	}{
+		{
+			name:     "внутри дневного промежутка",
+			window:   window(Time{Hours: 10}, Time{Hours: 12}),
+			time:     Time{Hours: 11, Minutes: 30},
+			expected: true,
+		},
+		{
+			name:     "начало промежутка",
+			window:   window(Time{Hours: 10}, Time{Hours: 12}),
+			time:     Time{Hours: 10},
+			expected: true,
+		},
+		{
+			name:     "конец промежутка",
+			window:   window(Time{Hours: 10}, Time{Hours: 12}),
+			time:     Time{Hours: 12},
+			expected: true,
+		},
+		{
+			name:   "вне дневного промежутка",
+			window: window(Time{Hours: 10}, Time{Hours: 12}),
+			time:   Time{Hours: 12, Seconds: 1},
+		},
+		{
+			name:     "ночной промежуток до полуночи",
+			window:   window(Time{Hours: 22}, Time{Hours: 2}),
+			time:     Time{Hours: 23},
+			expected: true,
+		},
+		{
+			name:     "ночной промежуток после полуночи",
+			window:   window(Time{Hours: 22}, Time{Hours: 2}),
+			time:     Time{Hours: 1, Minutes: 59},
+			expected: true,
+		},
+		{
+			name:   "вне ночного промежутка",
+			window: window(Time{Hours: 22}, Time{Hours: 2}),
+			time:   Time{Hours: 12},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.Contains(tt.time); got != tt.expected {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWindowGetTimezones(t *testing.T) {
+	tests := []struct {
+		name        string
+		window      Window
+		now         time.Time
+		minDuration time.Duration
+		expected    []timezone.Range
+	}{
+		{
+			name:     "ночной промежуток после полуночи по Гринвичу",
+			window:   window(Time{Hours: 22}, Time{Hours: 2}),
+			now:      time.Date(2026, 1, 1, 0, 30, 0, 0, time.UTC),
+			expected: []timezone.Range{{From: -150, To: 90}},
+		},
+		{
+			name:     "ночной промежуток перед полуночью по Гринвичу",
+			window:   window(Time{Hours: 22}, Time{Hours: 2}),
+			now:      time.Date(2026, 1, 1, 23, 30, 0, 0, time.UTC),
+			expected: []timezone.Range{{From: -90, To: 150}},
+		},
+		{
+			name:     "короткий промежуток",
+			window:   window(Time{Hours: 10}, Time{Hours: 10, Seconds: 30}),
+			now:      time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			expected: []timezone.Range{{From: 60, To: 60}},
+		},
+		{
+			name:        "расширение короткого промежутка",
+			window:      window(Time{Hours: 10}, Time{Hours: 10, Seconds: 30}),
+			now:         time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			minDuration: 5 * time.Minute,
+			expected:    []timezone.Range{{From: 60, To: 65}},
+		},
+		{
+			name:     "короткий промежуток между минутами",
+			window:   window(Time{Hours: 10, Seconds: 10}, Time{Hours: 10, Seconds: 40}),
+			now:      time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			expected: []timezone.Range{},
+		},
+		{
+			name:        "расширение промежутка между минутами",
+			window:      window(Time{Hours: 10, Seconds: 10}, Time{Hours: 10, Seconds: 40}),
+			now:         time.Date(2026, 1, 1, 9, 0, 0, 0, time.UTC),
+			minDuration: time.Minute,
+			expected:    []timezone.Range{{From: 61, To: 61}},
+		},
+		{
+			name:        "расширение не длиннее суток",
+			window:      window(Time{Hours: 10}, Time{Hours: 11}),
+			now:         time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+			minDuration: 48 * time.Hour,
+			expected:    []timezone.Range{{From: timezone.MinTimezone, To: 599}, {From: 600, To: timezone.MaxTimezone}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.GetTimezones(tt.now, tt.minDuration); !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("ожидалось %v, получено %v", tt.expected, got)
+			}
+		})
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"github.com/getsentry/sentry-go"
 	"github.com/wolframdeus/noitifications-service/internal"
 	"github.com/wolframdeus/noitifications-service/internal/appid"
 	"github.com/wolframdeus/noitifications-service/internal/errors"
 	"github.com/wolframdeus/noitifications-service/internal/notification"
 	"github.com/wolframdeus/noitifications-service/internal/providers/mongodb"
+	"github.com/wolframdeus/noitifications-service/internal/senders/vk"
 	"github.com/wolframdeus/noitifications-service/internal/service"
 	"github.com/wolframdeus/noitifications-service/internal/task"
 	"github.com/wolframdeus/noitifications-service/internal/taskid"
@@ -21,14 +23,19 @@ const (
 )
 
 func main() {
-	provider, err := mongodb.New("localhost", 27017, "notifications-service", 100)
+	provider, err := mongodb.New("localhost", 27017, "notifications-service", 100, mongodb.NewOptions{})
 	if err != nil {
 		panic(err)
 	}
 
 	// Создаём новый сервис.
 	// FIXME: access token
-	s, err := service.New(provider, "accessToken", service.NewOptions{
+	sender := vk.New(
+		map[appid.Id]string{HealthAppId: "accessToken"},
+		vk.NewOptions{Concurrency: 10, RequestsPerSecond: 3},
+	)
+
+	s, err := service.New(provider, sender, service.NewOptions{
 		TickInterval: 10 * time.Minute,
 		SentryOptions: &sentry.ClientOptions{
 			Dsn:              "https://792ef54fbc6e40eaaa6123514e06948a@o992980.ingest.sentry.io/6625183",
@@ -40,13 +47,27 @@ func main() {
 		panic(err)
 	}
 
+	// Создаем промежуток отправки уведомления с 00:00 до 02:00 по локальному
+	// времени пользователя.
+	from, err := internal.NewTime(00, 00)
+	if err != nil {
+		panic(err)
+	}
+	to, err := internal.NewTime(2, 00)
+	if err != nil {
+		panic(err)
+	}
+	window, err := internal.NewWindow(from, to)
+	if err != nil {
+		panic(err)
+	}
+
 	// Добавляем новую задачу.
-	s.AddTask(
+	err = s.AddTask(
 		*task.NewTask(
 			HealthSomeTaskId1,
 			HealthAppId,
-			internal.NewTime(00, 00),
-			internal.NewTime(2, 00),
+			*window,
 			func(users []user.User) ([]notification.Params, *errors.TaskError) {
 				res := make([]notification.Params, len(users))
 
@@ -57,8 +78,11 @@ func main() {
 			},
 		),
 	)
+	if err != nil {
+		panic(err)
+	}
 
-	if err := s.SetAllowStatusForUser(898, 521, true, nil); err != nil {
+	if err := s.SetAllowStatusForUser(context.Background(), 898, 521, true, nil); err != nil {
 		log.Println(err)
 	}
 	s.Cleanup()